@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	exporter "haproxy-table-exporter/pkg"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Flags shared by the commands changing stick-tables
+var (
+	adminKey     string
+	adminFilter  string
+	adminDryRun  bool
+	adminYes     bool
+	auditLogFile string
+)
+
+// addAdminFlags registers the flags selecting the entries to change and controlling how they are changed
+func addAdminFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&adminKey, "key", "k", "", "Key of the entry to change")
+	cmd.Flags().StringVar(&adminFilter, "filter", "", "Change the entries matching '<data type> <operator> <value>', e.g. 'gpc0 gt 0'")
+	cmd.Flags().BoolVar(&adminDryRun, "dry-run", false, "Print the commands without sending them")
+	cmd.Flags().BoolVarP(&adminYes, "yes", "y", false, "Don't ask for confirmation before changing several entries")
+	cmd.Flags().StringVar(&auditLogFile, "audit-log", "", "File to append the audit log of the changes to, in JSON, on top of the regular logs")
+	cmd.MarkFlagsMutuallyExclusive("key", "filter")
+}
+
+// adminTarget returns the header of the table and the entries selected by --key or --filter.
+// The key is validated against the type of the table and returned in the form HAProxy expects.
+func adminTarget(ctx context.Context) (exporter.TableHeader, string, *exporter.Filter, []exporter.Entry, error) {
+	var filter *exporter.Filter
+	// The key is sent to HAProxy before its type can be checked, a separator would smuggle
+	// another command past the dry run and the audit log
+	if adminKey != "" {
+		if err := exporter.CheckArgument("key", adminKey); err != nil {
+			return exporter.TableHeader{}, "", nil, nil, err
+		}
+	}
+	opts := exporter.QueryOptions{Table: stickTable}
+	if adminFilter != "" {
+		f, err := exporter.ParseFilter(adminFilter)
+		if err != nil {
+			return exporter.TableHeader{}, "", nil, nil, err
+		}
+		filter = &f
+		opts = f.QueryOptions(stickTable)
+	}
+	// The type of the table is only known once it is queried, the key is checked against it below
+	opts.Key = adminKey
+	header, entries, err := newClient().ShowTable(ctx, opts)
+	if err != nil {
+		return exporter.TableHeader{}, "", nil, nil, err
+	}
+	key := ""
+	if adminKey != "" {
+		if key, err = exporter.ParseKey(header.Type, adminKey); err != nil {
+			return exporter.TableHeader{}, "", nil, nil, err
+		}
+	}
+
+	return header, key, filter, entries, nil
+}
+
+// auditLogger returns the logger recording the changes, which also writes to --audit-log when it is set
+func auditLogger() (*slog.Logger, func() error, error) {
+	logger := slog.Default().With("audit", true)
+	if auditLogFile == "" {
+		return logger, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(auditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to open audit log: %v", err)
+	}
+	fileLogger := slog.New(slog.NewJSONHandler(f, nil))
+
+	return slog.New(teeHandler{logger.Handler(), fileLogger.Handler()}), f.Close, nil
+}
+
+// confirm asks a yes/no question on stderr and reads the answer from stdin
+func confirm(in io.Reader, question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// applyCommands prints the commands on a dry run, otherwise sends them one by one and stops at the first failure
+func applyCommands(ctx context.Context, audit *slog.Logger, commands []string) error {
+	client := newClient()
+	for _, c := range commands {
+		if adminDryRun {
+			fmt.Println(c)
+			continue
+		}
+		if err := exporter.ExecAdminCommand(ctx, client, audit, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
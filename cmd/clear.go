@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	exporter "haproxy-table-exporter/pkg"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	clearAll bool
+	// clearCmd removes entries from a stick-table, e.g. to unblock a client
+	clearCmd = &cobra.Command{
+		Use:   "clear",
+		Short: "Remove entries from a stick-table",
+		Long: `
+Removes a single entry with --key, the entries matching --filter or every entry
+with --all. Removing more than one entry asks for confirmation unless --yes is given.
+Every change is recorded in the audit log.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if adminKey == "" && adminFilter == "" && !clearAll {
+				return fmt.Errorf("One of --key, --filter or --all is required")
+			}
+			if err := checkSocket(); err != nil {
+				return err
+			}
+			audit, closeAudit, err := auditLogger()
+			if err != nil {
+				return err
+			}
+			defer closeAudit()
+
+			header, key, filter, entries, err := adminTarget(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if clearAll {
+				entries = nil
+			}
+			if len(entries) == 0 && !clearAll {
+				fmt.Fprintf(os.Stderr, "No entry of %s matches\n", header.Name)
+				return nil
+			}
+			command := exporter.ClearCommand(header.Name, key, filter)
+
+			count := len(entries)
+			if clearAll {
+				count = header.Used
+			}
+			if key == "" && !adminDryRun && !adminYes {
+				if !confirm(os.Stdin, fmt.Sprintf("Remove %d entries from %s?", count, header.Name)) {
+					return fmt.Errorf("Aborted")
+				}
+			}
+
+			return applyCommands(cmd.Context(), audit, []string{command})
+		},
+	}
+)
+
+func init() {
+	addAdminFlags(clearCmd)
+	clearCmd.Flags().BoolVar(&clearAll, "all", false, "Remove every entry of the table")
+	clearCmd.MarkFlagsMutuallyExclusive("key", "all")
+	clearCmd.MarkFlagsMutuallyExclusive("filter", "all")
+	rootCmd.AddCommand(clearCmd)
+}
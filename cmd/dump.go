@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	exporter "haproxy-table-exporter/pkg"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	dumpFile     string
+	dumpDataType string
+	// dumpCmd saves the response of HAProxy to replay it with --from-file
+	dumpCmd = &cobra.Command{
+		Use:   "dump",
+		Short: "Save the raw response of HAProxy for a stick-table to a file",
+		Long: `
+Sends the same "show table" command as the exporter and saves the response of HAProxy
+as received. The file can be replayed with the --from-file flag to reproduce an issue
+without access to HAProxy.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkSocket(); err != nil {
+				return err
+			}
+			if minimumRequestRate < 0 {
+				return fmt.Errorf("Invalid value for minRequestRate: %d", minimumRequestRate)
+			}
+
+			w := os.Stdout
+			if dumpFile != "-" {
+				f, err := os.Create(dumpFile)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			table := exporter.Table{Name: stickTable, DataType: dumpDataType}
+
+			return exporter.Dump(cmd.Context(), newClient(), table, minimumRequestRate, w)
+		},
+	}
+)
+
+func init() {
+	dumpCmd.Flags().StringVarP(&dumpFile, "output", "o", "-", "File to save the response to, - for stdout")
+	dumpCmd.Flags().StringVar(&dumpDataType, "data-type", "http_req_rate", "Data type to filter the entries on")
+	rootCmd.AddCommand(dumpCmd)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	exporter "haproxy-table-exporter/pkg"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyKey   string
+	historySince time.Duration
+	historyUntil string
+	historyTop   int
+	// historyCmd queries the snapshots recorded by the history backend
+	historyCmd = &cobra.Command{
+		Use:   "history",
+		Short: "Show the recorded values of a key or the top keys of a stick-table over time",
+		Long: `
+Reads the snapshots recorded by the history backend, to investigate an incident after
+the entries expired from HAProxy. With --key, prints the value of the key in every
+snapshot of the window, otherwise the keys with the highest peak value. When the keys
+are anonymized, an address given with --key is anonymized the same way.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if historySince <= 0 {
+				return fmt.Errorf("Invalid value for since: %s", historySince)
+			}
+			if historyTop < 0 {
+				return fmt.Errorf("Invalid value for top: %d", historyTop)
+			}
+			to := time.Now()
+			if historyUntil != "" {
+				var err error
+				if to, err = time.Parse(time.RFC3339, historyUntil); err != nil {
+					return fmt.Errorf("Invalid value for until: %v", err)
+				}
+			}
+			from := to.Add(-historySince)
+
+			h, err := exporter.OpenHistory(historyFile, true)
+			if err != nil {
+				return err
+			}
+			defer h.Close()
+
+			if historyKey != "" {
+				key := historyKey
+				// The history of anonymized keys is recorded under the anonymized key
+				if ip, err := netip.ParseAddr(historyKey); err == nil {
+					anonymizer, err := newAnonymizer()
+					if err != nil {
+						return err
+					}
+					key = anonymizer.Key(ip)
+				}
+				dataType, points, err := h.KeySeries(stickTable, key, from, to)
+				if err != nil {
+					return err
+				}
+				return exporter.WriteKeySeries(os.Stdout, dataType, points)
+			}
+			dataType, rows, err := h.TopKeys(stickTable, from, to, historyTop)
+			if err != nil {
+				return err
+			}
+			return exporter.WriteTopKeys(os.Stdout, dataType, rows)
+		},
+	}
+)
+
+func init() {
+	historyCmd.Flags().StringVarP(&historyKey, "key", "k", "", "Key to show the values of, the top keys are shown when empty")
+	historyCmd.Flags().DurationVar(&historySince, "since", time.Hour, "Length of the window")
+	historyCmd.Flags().StringVar(&historyUntil, "until", "", "End of the window in RFC 3339 format (e.g. 2024-05-02T10:00:00Z), now when empty")
+	historyCmd.Flags().IntVar(&historyTop, "top", 20, "Number of top keys to show, 0 for all")
+	rootCmd.AddCommand(historyCmd)
+}
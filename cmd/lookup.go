@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	exporter "haproxy-table-exporter/pkg"
+	"log/slog"
+	"net/netip"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	lookupNetworks []string
+	lookupTable    bool
+	// lookupCmd reverses the keys anonymized with a HMAC, for authorized investigators
+	lookupCmd = &cobra.Command{
+		Use:   "lookup <key>...",
+		Short: "Find the client IP addresses behind keys anonymized with --anonymize hmac",
+		Long: `
+Reverses the keys anonymized with --anonymize hmac, which requires the secret given with
+--anonymize-secret-file. A key is searched among the addresses currently in the
+stick-table and in the networks given with --network. An address is printed with its
+anonymized key, to search the metrics for it. Every lookup is logged.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if anonymize != exporter.AnonymizeHMAC {
+				return fmt.Errorf("Only keys anonymized with --anonymize hmac can be looked up")
+			}
+			anonymizer, err := newAnonymizer()
+			if err != nil {
+				return err
+			}
+			networks := make([]netip.Prefix, 0, len(lookupNetworks))
+			for _, n := range lookupNetworks {
+				network, err := netip.ParsePrefix(n)
+				if err != nil {
+					return fmt.Errorf("Invalid value for network: %v", err)
+				}
+				networks = append(networks, network)
+			}
+
+			var tableKeys []string
+			if lookupTable {
+				if err := checkSocket(); err != nil {
+					return err
+				}
+				_, entries, err := newClient().ShowTable(cmd.Context(), exporter.QueryOptions{Table: stickTable})
+				if err != nil {
+					return err
+				}
+				for _, e := range entries {
+					tableKeys = append(tableKeys, e.Key)
+				}
+			}
+
+			notFound := 0
+			for _, arg := range args {
+				if ip, err := netip.ParseAddr(arg); err == nil {
+					fmt.Fprintf(os.Stdout, "%s\t%s\n", anonymizer.Key(ip), ip)
+					continue
+				}
+				slog.Info("Looking up anonymized key", "key", arg, "table", stickTable, "networks", lookupNetworks)
+				found, err := anonymizer.Lookup(arg, tableKeys)
+				if err != nil {
+					return err
+				}
+				for _, network := range networks {
+					ips, err := anonymizer.LookupNetwork(arg, network)
+					if err != nil {
+						return err
+					}
+					for _, ip := range ips {
+						found = append(found, ip.String())
+					}
+				}
+				if len(found) == 0 {
+					fmt.Fprintf(os.Stdout, "%s\t-\n", arg)
+					notFound++
+				}
+				for _, key := range found {
+					fmt.Fprintf(os.Stdout, "%s\t%s\n", arg, key)
+				}
+			}
+			if notFound > 0 {
+				return fmt.Errorf("%d of the keys weren't found", notFound)
+			}
+
+			return nil
+		},
+	}
+)
+
+func init() {
+	lookupCmd.Flags().StringSliceVar(&lookupNetworks, "network", nil, "Networks to search the keys in, of up to 2^24 addresses (e.g. 192.0.2.0/24,2001:db8::/104)")
+	lookupCmd.Flags().BoolVar(&lookupTable, "search-table", true, "Search the keys among the addresses currently in the stick-table")
+	rootCmd.AddCommand(lookupCmd)
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	exporter "haproxy-table-exporter/pkg"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	queryFormat   string
+	querySortBy   string
+	queryLimit    int
+	queryKey      string
+	queryDataType string
+	// queryCmd prints the entries of a stick-table for humans
+	queryCmd = &cobra.Command{
+		Use:   "query",
+		Short: "Print the entries of a stick-table as a table, JSON or CSV",
+		Long: `
+Sends a "show table" command to HAProxy and prints the entries sorted, by default
+by the filtered data type. Entries are filtered like the exporter does, with
+data.<data-type> gt <minimum-request-rate>, unless a single key is looked up.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkSocket(); err != nil {
+				return err
+			}
+			if minimumRequestRate < 0 {
+				return fmt.Errorf("Invalid value for minRequestRate: %d", minimumRequestRate)
+			}
+			if queryLimit < 0 {
+				return fmt.Errorf("Invalid value for limit: %d", queryLimit)
+			}
+			switch queryFormat {
+			case exporter.FormatTable, exporter.FormatJSON, exporter.FormatCSV:
+			default:
+				return fmt.Errorf("Invalid value for format: %s", queryFormat)
+			}
+
+			_, entries, err := newClient().ShowTable(cmd.Context(), exporter.QueryOptions{
+				Table:        stickTable,
+				DataType:     queryDataType,
+				MinimumValue: minimumRequestRate,
+				Key:          queryKey,
+			})
+			if err != nil {
+				return err
+			}
+			sortBy := querySortBy
+			if sortBy == "" {
+				sortBy = queryDataType
+			}
+			exporter.SortEntries(entries, sortBy)
+			if queryLimit > 0 && len(entries) > queryLimit {
+				entries = entries[:queryLimit]
+			}
+
+			return exporter.WriteEntries(os.Stdout, queryFormat, entries)
+		},
+	}
+)
+
+func init() {
+	queryCmd.Flags().StringVarP(&queryFormat, "format", "f", exporter.FormatTable, "Output format: table, json or csv")
+	queryCmd.Flags().StringVar(&querySortBy, "sort-by", "", "Sort by key, use, exp, shard or a data type, defaults to the data type")
+	queryCmd.Flags().IntVarP(&queryLimit, "limit", "l", 0, "Maximum number of entries to print, 0 for all")
+	queryCmd.Flags().StringVarP(&queryKey, "key", "k", "", "Look up a single key instead of filtering the entries")
+	queryCmd.Flags().StringVar(&queryDataType, "data-type", "http_req_rate", "Data type to filter the entries on, empty to print the whole table")
+	rootCmd.AddCommand(queryCmd)
+}
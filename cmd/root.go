@@ -1,10 +1,17 @@
 package cmd
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	exporter "haproxy-table-exporter/pkg"
 	"io/fs"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -15,6 +22,62 @@ var (
 	prometheusFile     string
 	stickTable         string
 	minimumRequestRate int
+	thresholds         map[string]int
+	maxThresholdKeys   int
+	haproxyConfig      string
+	expectedTypes      map[string]string
+	expectedSizes      map[string]string
+	expectedPeriods    map[string]string
+	onMismatch         string
+	logLevel           string
+	logFormat          string
+	fromFile           string
+	parseMode          string
+	outputFormat       string
+	timestamps         bool
+	listenAddress      string
+	backends           []string
+	pushURL            string
+	pushJob            string
+	pushInstance       string
+	pushTimeout        time.Duration
+	statsdAddress      string
+	statsdPrefix       string
+	statsdTags         []string
+	statsdKeyTag       string
+	statsdMTU          int
+	otlpEndpoint       string
+	otlpProtocol       string
+	otlpInsecure       bool
+	otlpHeaders        map[string]string
+	otlpInstance       string
+	ndjsonFile         string
+	ndjsonMaxSize      int64
+	ndjsonMaxBackups   int
+	interval           time.Duration
+	eventSink          string
+	syslogNetwork      string
+	syslogAddress      string
+	syslogSDID         string
+	lokiURL            string
+	lokiLabels         map[string]string
+	lokiHeaders        map[string]string
+	historyFile        string
+	historyRetention   time.Duration
+	counterRetention   time.Duration
+	metricPrefix       string
+	renameLabels       map[string]string
+	extraLabels        map[string]string
+	keyRulesFile       string
+	anonymize          string
+	secretFile         string
+	dialTimeout        time.Duration
+	writeTimeout       time.Duration
+	readTimeout        time.Duration
+	timeout            time.Duration
+	retries            int
+	retryBackoff       time.Duration
+	retryMaxBackoff    time.Duration
 	rootCmd            = &cobra.Command{
 		Use:   "haproxy-table-exporter",
 		Short: "A Prometheus textfile exporter for querying and exporting metrics from a specific stick-table in HAProxy",
@@ -23,43 +86,451 @@ A Prometheus exporter for querying HAProxy stick-tables and generating metrics.
 It sends the "show table <stick-table-name>" command to HAProxy via a UNIX socket
 and creates the metric haproxy_client_request_rate with client IPs as labels.
 
-This tool supports only IP-type stick-tables with the http_req_rate data store.
+This tool supports only IP-type stick-tables, by default with the http_req_rate data
+store. The stick-tables, their data types and deny thresholds can also be read from
+the HAProxy configuration.
 It is intended to run as a cron job and requires write access to the UNIX socket
-and the metrics directory.`,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			f, err := os.Stat(socket)
-			if os.IsNotExist(err) {
+and the metrics directory. With --listen-address, it serves the metrics over HTTP
+instead, querying HAProxy at every scrape. Where Prometheus can't scrape, --backend
+pushes them to a Pushgateway, a remote-write endpoint, a DogStatsD server or an
+OpenTelemetry collector, or writes the entries as NDJSON for log pipelines.
+With --interval, it runs as a daemon and can send an event to syslog or Loki when
+a key crosses the threshold of its stick-table, or record the values in a local
+history queried by the history command.`,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			logger, err := newLogger()
+			if err != nil {
 				return err
 			}
-			if f.Mode().Type() != fs.ModeSocket {
-				return fmt.Errorf("%s is not a UNIX socket", f.Name())
+			slog.SetDefault(logger)
+			if err := timeouts().Validate(); err != nil {
+				return err
+			}
+			if retries < 0 {
+				return fmt.Errorf("Invalid value for retries: %d", retries)
+			}
+			// Flags are valid at this point, errors from now on aren't usage errors
+			cmd.SilenceUsage = true
+
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if fromFile == "" {
+				if err := checkSocket(); err != nil {
+					return err
+				}
 			}
 			if minimumRequestRate < 0 {
 				return fmt.Errorf("Invalid value for minRequestRate: %d", minimumRequestRate)
 			}
-			p, err := os.OpenFile(prometheusFile, os.O_RDWR, 0664)
-			if err != nil {
-				if os.IsPermission(err) {
-					return fmt.Errorf("No write access to %s", prometheusFile)
+			if maxThresholdKeys < 0 {
+				return fmt.Errorf("Invalid value for max-threshold-keys: %d", maxThresholdKeys)
+			}
+			switch parseMode {
+			case exporter.ParseStrict, exporter.ParseLenient:
+			default:
+				return fmt.Errorf("Invalid value for parse-mode: %s", parseMode)
+			}
+			switch onMismatch {
+			case exporter.MismatchIgnore, exporter.MismatchWarn, exporter.MismatchFail:
+			default:
+				return fmt.Errorf("Invalid value for on-mismatch: %s", onMismatch)
+			}
+			switch outputFormat {
+			case exporter.OutputText, exporter.OutputOpenMetrics:
+			default:
+				return fmt.Errorf("Invalid value for output-format: %s", outputFormat)
+			}
+			textfile := false
+			for _, backend := range backends {
+				switch backend {
+				case exporter.BackendTextfile:
+					textfile = true
+				case exporter.BackendPushgateway, exporter.BackendRemoteWrite:
+					if pushURL == "" {
+						return fmt.Errorf("push-url is required by the %s backend", backend)
+					}
+					if backend == exporter.BackendPushgateway && timestamps {
+						return fmt.Errorf("The Pushgateway rejects samples with timestamps, remove --timestamps")
+					}
+				case exporter.BackendStatsD:
+					switch statsdKeyTag {
+					case exporter.StatsDKeyTagKey, exporter.StatsDKeyTagPrefix:
+					default:
+						return fmt.Errorf("Invalid value for statsd-key-tag: %s", statsdKeyTag)
+					}
+				case exporter.BackendNDJSON:
+					if ndjsonMaxSize < 0 || ndjsonMaxBackups < 0 {
+						return fmt.Errorf("ndjson-max-size and ndjson-max-backups can't be negative")
+					}
+				case exporter.BackendEvents:
+					if interval == 0 {
+						return fmt.Errorf("The events backend compares consecutive runs, set --interval")
+					}
+					switch eventSink {
+					case exporter.SinkSyslog:
+						if err := (exporter.SyslogSink{SDID: syslogSDID}).Validate(); err != nil {
+							return err
+						}
+					case exporter.SinkLoki:
+						if lokiURL == "" {
+							return fmt.Errorf("loki-url is required by the loki event sink")
+						}
+					default:
+						return fmt.Errorf("Invalid value for event-sink: %s", eventSink)
+					}
+				case exporter.BackendHistory:
+					if historyRetention < 0 {
+						return fmt.Errorf("history-retention argument can't be negative")
+					}
+				case exporter.BackendOTLP:
+					switch otlpProtocol {
+					case exporter.OTLPGRPC, exporter.OTLPHTTP:
+					default:
+						return fmt.Errorf("Invalid value for otlp-protocol: %s", otlpProtocol)
+					}
+				default:
+					return fmt.Errorf("Invalid value for backend: %s", backend)
+				}
+			}
+			if interval < 0 {
+				return fmt.Errorf("interval argument can't be negative")
+			}
+			// The server queries HAProxy at every scrape, it doesn't run the backends on a ticker
+			if listenAddress != "" && (interval > 0 || cmd.Flags().Changed("backend")) {
+				return fmt.Errorf("listen-address serves the metrics at every scrape, it can't be combined with --interval or --backend")
+			}
+			if counterRetention < 0 {
+				return fmt.Errorf("counter-retention argument can't be negative")
+			}
+			if pushTimeout < 0 {
+				return fmt.Errorf("push-timeout argument can't be negative")
+			}
+			if listenAddress == "" && textfile {
+				p, err := os.OpenFile(prometheusFile, os.O_RDWR, 0664)
+				if err != nil {
+					if os.IsPermission(err) {
+						return fmt.Errorf("No write access to %s", prometheusFile)
+					}
 				}
+				p.Close()
 			}
-			p.Close()
 
-			return exporter.Run(stickTable, socket, minimumRequestRate, prometheusFile)
+			tables := []exporter.Table{{Name: stickTable, DataType: "http_req_rate"}}
+			tableThresholds := thresholds
+			expectations := make(map[string]exporter.TableExpectation)
+			if haproxyConfig != "" {
+				c, err := exporter.ReadHAProxyConfig(haproxyConfig)
+				if err != nil {
+					return fmt.Errorf("Failed to read HAProxy configuration: %v", err)
+				}
+				tables, tableThresholds, err = autoConfigure(c, cmd.Flags().Changed("stick-table"))
+				if err != nil {
+					return err
+				}
+				expectations = exporter.ExpectationsFromHAProxyConfig(c, tables)
+			}
+			if err := overrideExpectations(expectations); err != nil {
+				return err
+			}
+			if fromFile != "" && len(tables) != 1 {
+				return fmt.Errorf("from-file holds a single stick-table, select one with --stick-table")
+			}
+			relabel := exporter.RelabelConfig{Prefix: metricPrefix, LabelNames: renameLabels, ExtraLabels: extraLabels}
+			if keyRulesFile != "" {
+				rules, err := exporter.ReadKeyRules(keyRulesFile)
+				if err != nil {
+					return fmt.Errorf("Failed to read key rules: %v", err)
+				}
+				relabel.KeyRules = rules
+			}
+			if err := relabel.Validate(); err != nil {
+				return err
+			}
+			anonymizer, err := newAnonymizer()
+			if err != nil {
+				return err
+			}
+
+			cfg := exporter.Config{
+				Tables:             tables,
+				Socket:             socket,
+				Timeouts:           timeouts(),
+				Retry:              retryPolicy(),
+				MinimumRequestRate: minimumRequestRate,
+				Backends:           backends,
+				PrometheusFile:     prometheusFile,
+				Push: exporter.PushConfig{
+					URL:      pushURL,
+					Job:      pushJob,
+					Instance: pushInstance,
+					Timeout:  pushTimeout,
+				},
+				StatsD: exporter.StatsDConfig{
+					Address: statsdAddress,
+					Prefix:  statsdPrefix,
+					Tags:    statsdTags,
+					KeyTag:  statsdKeyTag,
+					MTU:     statsdMTU,
+				},
+				NDJSON: exporter.NDJSONConfig{
+					Path:       ndjsonFile,
+					Instance:   pushInstance,
+					MaxSize:    ndjsonMaxSize,
+					MaxBackups: ndjsonMaxBackups,
+				},
+				Events: exporter.EventsConfig{
+					Sink:   eventSink,
+					Syslog: exporter.SyslogSink{Network: syslogNetwork, Address: syslogAddress, Hostname: hostname(), SDID: syslogSDID},
+					Loki:   exporter.LokiSink{URL: lokiURL, Labels: lokiLabels, Headers: lokiHeaders},
+				},
+				History: exporter.HistoryConfig{Path: historyFile, Retention: historyRetention},
+				OTLP: exporter.OTLPConfig{
+					Endpoint: otlpEndpoint,
+					Protocol: otlpProtocol,
+					Insecure: otlpInsecure,
+					Headers:  otlpHeaders,
+					Host:     hostname(),
+					Instance: cmp.Or(otlpInstance, socket),
+					Timeout:  pushTimeout,
+				},
+				OutputFormat:         outputFormat,
+				Timestamps:           timestamps,
+				Relabel:              relabel,
+				Anonymizer:           anonymizer,
+				Thresholds:           tableThresholds,
+				MaxOverThresholdKeys: maxThresholdKeys,
+				Expectations:         expectations,
+				OnMismatch:           onMismatch,
+				ParseMode:            parseMode,
+				FromFile:             fromFile,
+				Logger:               slog.Default(),
+			}
+			if listenAddress != "" || interval > 0 {
+				cfg.Counters = exporter.NewCounterTracker(counterRetention)
+			}
+			if listenAddress != "" {
+				return exporter.Serve(cmd.Context(), cfg, listenAddress)
+			}
+			if interval > 0 {
+				return exporter.Daemon(cmd.Context(), cfg, interval)
+			}
+
+			return exporter.Run(cmd.Context(), cfg)
 		},
 	}
 )
 
+// checkSocket verifies that the socket given on the command line is a UNIX socket
+func checkSocket() error {
+	f, err := os.Stat(socket)
+	// HAProxy removes its socket for a short time when it reloads, the commands are retried
+	if os.IsNotExist(err) && retries > 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if f.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s is not a UNIX socket", f.Name())
+	}
+
+	return nil
+}
+
+// autoConfigure returns the tables and thresholds declared in the HAProxy configuration.
+// Thresholds given on the command line take precedence, and when onlyStickTable is true
+// only the stick-table given on the command line is queried.
+func autoConfigure(c *exporter.HAProxyConfig, onlyStickTable bool) ([]exporter.Table, map[string]int, error) {
+	tables, tableThresholds := c.AutoConfigure()
+	if onlyStickTable {
+		var selected []exporter.Table
+		for _, t := range tables {
+			if t.Name == stickTable {
+				selected = append(selected, t)
+			}
+		}
+		tables = selected
+	}
+	if len(tables) == 0 {
+		return nil, nil, fmt.Errorf("No ip stick-table with stored data types found in %s", haproxyConfig)
+	}
+	for table, threshold := range thresholds {
+		tableThresholds[table] = threshold
+	}
+
+	return tables, tableThresholds, nil
+}
+
+// overrideExpectations applies the expectations given on the command line on top of the
+// ones read from the HAProxy configuration. Sizes and periods use the HAProxy syntax, e.g. 1m and 60s.
+func overrideExpectations(expectations map[string]exporter.TableExpectation) error {
+	for table, tableType := range expectedTypes {
+		e := expectations[table]
+		e.Type = tableType
+		expectations[table] = e
+	}
+	for table, size := range expectedSizes {
+		n, err := exporter.ParseSize(size)
+		if err != nil {
+			return fmt.Errorf("Invalid value for expected-size of %s: %v", table, err)
+		}
+		e := expectations[table]
+		e.Size = n
+		expectations[table] = e
+	}
+	for table, period := range expectedPeriods {
+		d, err := exporter.ParseTime(period)
+		if err != nil {
+			return fmt.Errorf("Invalid value for expected-period of %s: %v", table, err)
+		}
+		e := expectations[table]
+		e.Period = d
+		expectations[table] = e
+	}
+
+	return nil
+}
+
+// timeouts returns the timeouts of the commands sent to HAProxy given on the command line
+func timeouts() exporter.Timeouts {
+	return exporter.Timeouts{Dial: dialTimeout, Write: writeTimeout, Read: readTimeout, Overall: timeout}
+}
+
+// retryPolicy returns the retries of the commands sent to HAProxy given on the command line
+func retryPolicy() exporter.RetryPolicy {
+	return exporter.RetryPolicy{Retries: retries, Backoff: retryBackoff, MaxBackoff: retryMaxBackoff}
+}
+
+// newClient returns a client for the socket given on the command line
+func newClient() *exporter.Client {
+	client := exporter.NewClient(socket, timeouts(), slog.Default().With("socket", socket))
+	client.Retry = retryPolicy()
+	return client
+}
+
+// newAnonymizer returns the anonymizer given on the command line, nil when the keys aren't anonymized
+func newAnonymizer() (*exporter.Anonymizer, error) {
+	if anonymize == "" {
+		return nil, nil
+	}
+	var secret []byte
+	if anonymize == exporter.AnonymizeHMAC {
+		if secretFile == "" {
+			return nil, fmt.Errorf("anonymize-secret-file is required by the hmac anonymization")
+		}
+		var err error
+		if secret, err = exporter.ReadSecret(secretFile); err != nil {
+			return nil, fmt.Errorf("Failed to read the anonymization secret: %v", err)
+		}
+	}
+	return exporter.NewAnonymizer(anonymize, secret)
+}
+
+// hostname returns the name of the host, the default instance label of the pushed metrics
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// newLogger returns a logger writing to stderr in the format and from the level given on the command line
+func newLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return nil, fmt.Errorf("Invalid value for log-level: %s", logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("Invalid value for log-format: %s", logFormat)
+	}
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
+// Commands are canceled on SIGINT and SIGTERM.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		stop()
+		attrs := []any{"command", rootCmd.Name(), "error", err, "error_class", exporter.ErrorClass(err)}
+		// The stage tells a slow HAProxy, timing out on read, from one which is down, timing out on dial
+		var timeoutErr *exporter.TimeoutError
+		if errors.As(err, &timeoutErr) {
+			attrs = append(attrs, "timeout_stage", timeoutErr.Stage)
+		}
+		slog.Error("Failed to run", attrs...)
 		os.Exit(1)
 	}
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&socket, "socket", "s", "/var/lib/haproxy/stats", "Path to the UNIX socket that HAProxy listens on")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Minimum level of the logs: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Format of the logs: text or json")
+	rootCmd.PersistentFlags().StringVarP(&socket, "socket", "s", "/var/lib/haproxy/stats", "Path to the UNIX socket that HAProxy listens on")
+	rootCmd.PersistentFlags().DurationVar(&dialTimeout, "dial-timeout", 1*time.Second, "Maximum time to connect to the socket, 0 for no limit")
+	rootCmd.PersistentFlags().DurationVar(&writeTimeout, "write-timeout", 1*time.Second, "Maximum time to send a command to HAProxy, 0 for no limit")
+	rootCmd.PersistentFlags().DurationVar(&readTimeout, "read-timeout", 5*time.Second, "Maximum time HAProxy can stay silent while sending a response, 0 for no limit")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 30*time.Second, "Maximum time of a whole command, 0 for no limit")
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", 3, "Number of retries of a command failing on a transient error, e.g. while HAProxy reloads")
+	rootCmd.PersistentFlags().DurationVar(&retryBackoff, "retry-backoff", 100*time.Millisecond, "Delay before the first retry, doubled after every retry with a random jitter")
+	rootCmd.PersistentFlags().DurationVar(&retryMaxBackoff, "retry-max-backoff", 2*time.Second, "Maximum delay between two retries")
 	rootCmd.Flags().StringVarP(&prometheusFile, "prometheus-file", "p", "/var/cache/textfile_collector/haproxy_rate_limit_entries.prom", "File to export the generated Prometheus metrics")
-	rootCmd.Flags().StringVarP(&stickTable, "stick-table", "t", "table_requests_limiter_src_ip", "Name of the stick-table to query for entries")
-	rootCmd.Flags().IntVarP(&minimumRequestRate, "minimum-request-rate", "m", 1, "Minimum request rate for a client IP to be included in the Prometheus metric")
+	rootCmd.PersistentFlags().StringVar(&historyFile, "history-file", "/var/lib/haproxy-table-exporter/history.db", "Database of the history backend and the history command")
+	rootCmd.PersistentFlags().StringVar(&anonymize, "anonymize", "", "Replace the client IP addresses in every output: hmac for a keyed hash, truncate for the /24 or /48 network, empty to keep them")
+	rootCmd.PersistentFlags().StringVar(&secretFile, "anonymize-secret-file", "", "File holding the secret of the hmac anonymization, at least 16 bytes")
+	rootCmd.PersistentFlags().StringVarP(&stickTable, "stick-table", "t", "table_requests_limiter_src_ip", "Name of the stick-table to query for entries")
+	rootCmd.PersistentFlags().IntVarP(&minimumRequestRate, "minimum-request-rate", "m", 1, "Minimum request rate for a client IP to be included in the Prometheus metric, lowered to the threshold of a stick-table below it")
+	rootCmd.Flags().StringVar(&outputFormat, "output-format", exporter.OutputText, "Format of the prometheus file: text or openmetrics")
+	rootCmd.Flags().BoolVar(&timestamps, "timestamps", false, "Attach the time HAProxy was queried to the samples, not supported by the node_exporter textfile collector")
+	rootCmd.Flags().StringSliceVar(&backends, "backend", []string{exporter.BackendTextfile}, "Where to deliver the metrics, one or more of textfile, pushgateway, remote-write, statsd, otlp, ndjson, events and history")
+	rootCmd.Flags().StringVar(&pushURL, "push-url", "", "Address of the Pushgateway, or URL of the remote-write endpoint")
+	rootCmd.Flags().StringVar(&pushJob, "push-job", exporter.DefaultPushJob, "Job label of the pushed metrics")
+	rootCmd.Flags().StringVar(&pushInstance, "push-instance", hostname(), "Instance label of the pushed metrics and instance of the NDJSON records")
+	rootCmd.Flags().DurationVar(&pushTimeout, "push-timeout", 10*time.Second, "Maximum time to push the metrics, 0 for no limit")
+	rootCmd.Flags().StringVar(&statsdAddress, "statsd-address", "127.0.0.1:8125", "Address of the DogStatsD server")
+	rootCmd.Flags().StringVar(&statsdPrefix, "statsd-prefix", exporter.DefaultStatsDPrefix, "Prefix of the StatsD metric names")
+	rootCmd.Flags().StringSliceVar(&statsdTags, "statsd-tags", nil, "Tags added to every StatsD metric (e.g. env:prod,dc:par1)")
+	rootCmd.Flags().StringVar(&statsdKeyTag, "statsd-key-tag", exporter.StatsDKeyTagKey, "Tag of the entries in StatsD: key for the client IP, or prefix to sum them per /24 or /64 network")
+	rootCmd.Flags().IntVar(&statsdMTU, "statsd-mtu", exporter.DefaultStatsDMTU, "Maximum size of a StatsD packet")
+	rootCmd.Flags().StringVar(&otlpEndpoint, "otlp-endpoint", "localhost:4317", "Address of the OpenTelemetry collector, usually port 4317 for grpc and 4318 for http")
+	rootCmd.Flags().StringVar(&otlpProtocol, "otlp-protocol", exporter.OTLPGRPC, "Protocol of the OpenTelemetry collector: grpc or http")
+	rootCmd.Flags().BoolVar(&otlpInsecure, "otlp-insecure", false, "Connect to the OpenTelemetry collector without TLS")
+	rootCmd.Flags().StringToStringVar(&otlpHeaders, "otlp-headers", nil, "Headers sent to the OpenTelemetry collector (e.g. authorization=Bearer xyz)")
+	rootCmd.Flags().StringVar(&otlpInstance, "otlp-instance", "", "haproxy.instance resource attribute of the exported metrics, the socket path when empty")
+	rootCmd.Flags().StringVar(&ndjsonFile, "ndjson-file", "-", "File the ndjson backend appends the entries to, - for stdout")
+	rootCmd.Flags().Int64Var(&ndjsonMaxSize, "ndjson-max-size", 0, "Size in bytes above which the NDJSON file is rotated, 0 to never rotate")
+	rootCmd.Flags().IntVar(&ndjsonMaxBackups, "ndjson-max-backups", 5, "Number of rotated NDJSON files to keep")
+	rootCmd.Flags().DurationVar(&interval, "interval", 0, "Run as a daemon querying HAProxy at this interval, 0 to run once")
+	rootCmd.Flags().StringVar(&eventSink, "event-sink", exporter.SinkSyslog, "Where the events backend sends the events: syslog or loki")
+	rootCmd.Flags().StringVar(&syslogNetwork, "syslog-network", "unixgram", "Network of the syslog server: unixgram, unix, udp or tcp")
+	rootCmd.Flags().StringVar(&syslogAddress, "syslog-address", "/dev/log", "Socket path or host:port of the syslog server")
+	rootCmd.Flags().StringVar(&syslogSDID, "syslog-sd-id", "", "ID of the structured data element holding the details of the events, name@<private enterprise number>, none when empty")
+	rootCmd.Flags().StringVar(&lokiURL, "loki-url", "", "Address of Loki, e.g. http://loki:3100")
+	rootCmd.Flags().StringToStringVar(&lokiLabels, "loki-labels", nil, "Labels added to the Loki streams (e.g. host=lb1)")
+	rootCmd.Flags().StringToStringVar(&lokiHeaders, "loki-headers", nil, "Headers sent to Loki (e.g. X-Scope-OrgID=tenant1)")
+	rootCmd.Flags().DurationVar(&historyRetention, "history-retention", exporter.DefaultHistoryRetention, "How long the history backend keeps the snapshots")
+	rootCmd.Flags().DurationVar(&counterRetention, "counter-retention", exporter.DefaultCounterRetention, "How long the counter of a client IP which left the stick-table is kept, in daemon or server mode")
+	rootCmd.Flags().StringVar(&metricPrefix, "metric-prefix", exporter.DefaultMetricPrefix, "Prefix of the metric names")
+	rootCmd.Flags().StringToStringVar(&renameLabels, "rename-label", nil, "New names of the labels of the metrics (e.g. client_ip=key,name=table)")
+	rootCmd.Flags().StringToStringVar(&extraLabels, "extra-label", nil, "Labels added to every metric (e.g. datacenter=par1,cluster=edge)")
+	rootCmd.Flags().StringVar(&keyRulesFile, "key-rules", "", "File of rules rewriting the client IP label, one regex matching the whole key and its replacement per line (e.g. 10\\.1\\..* tenant-a)")
+	rootCmd.Flags().StringVar(&listenAddress, "listen-address", "", "Serve the metrics on /metrics at this address, querying HAProxy at every scrape, instead of running the backends")
+	rootCmd.Flags().StringVar(&fromFile, "from-file", "", "Replay a response saved with the dump command instead of querying the socket")
+	rootCmd.Flags().StringToIntVar(&thresholds, "threshold", nil, "Request rate above which HAProxy denies a client, per stick-table (e.g. table_requests_limiter_src_ip=100)")
+	rootCmd.Flags().StringVar(&haproxyConfig, "haproxy-config", "", "HAProxy configuration file to read the stick-tables, their data types and deny thresholds from")
+	rootCmd.Flags().StringToStringVar(&expectedTypes, "expected-type", nil, "Expected type per stick-table (e.g. table_requests_limiter_src_ip=ip)")
+	rootCmd.Flags().StringToStringVar(&expectedSizes, "expected-size", nil, "Expected size per stick-table (e.g. table_requests_limiter_src_ip=1m)")
+	rootCmd.Flags().StringToStringVar(&expectedPeriods, "expected-period", nil, "Expected period of the exported rate per stick-table (e.g. table_requests_limiter_src_ip=60s)")
+	rootCmd.Flags().StringVar(&onMismatch, "on-mismatch", exporter.MismatchWarn, "What to do when a stick-table differs from the HAProxy configuration or the expected-* flags: ignore, warn or fail")
+	rootCmd.Flags().StringVar(&parseMode, "parse-mode", exporter.ParseStrict, "What to do with an entry which can't be exported: strict fails the run, lenient skips and counts it")
+	rootCmd.Flags().IntVar(&maxThresholdKeys, "max-threshold-keys", 20, "Maximum number of over-threshold client IPs to export per stick-table")
 }
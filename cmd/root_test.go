@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func Test_listenAddressCombinations(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "interval", args: []string{"--interval", "10s"}},
+		{name: "push backend", args: []string{"--backend", "statsd"}},
+		{name: "events backend", args: []string{"--interval", "10s", "--backend", "events"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The flags are global, they are set again by every case
+			interval = 0
+			backends = nil
+			args := append([]string{"--listen-address", "127.0.0.1:0", "--from-file", "testdata/missing.dump"}, tt.args...)
+			rootCmd.SetArgs(args)
+			err := rootCmd.ExecuteContext(context.Background())
+			if err == nil || !strings.Contains(err.Error(), "can't be combined") {
+				t.Errorf("Execute(%s) error = %v, want a rejected combination", strings.Join(args, " "), err)
+			}
+		})
+	}
+}
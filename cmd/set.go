@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	exporter "haproxy-table-exporter/pkg"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// setCmd changes the data of stick-table entries, e.g. to ban a client with gpc0=1
+var setCmd = &cobra.Command{
+	Use:   "set <data type>=<value>...",
+	Short: "Set data types of stick-table entries",
+	Long: `
+Sets data types, e.g. gpc0=1, of the entry of --key, creating it when needed, or of
+every entry matching --filter. Changing more than one entry asks for confirmation
+unless --yes is given. Every change is recorded in the audit log.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if adminKey == "" && adminFilter == "" {
+			return fmt.Errorf("One of --key or --filter is required")
+		}
+		data, err := exporter.ParseDataAssignments(args)
+		if err != nil {
+			return err
+		}
+		if err := checkSocket(); err != nil {
+			return err
+		}
+		audit, closeAudit, err := auditLogger()
+		if err != nil {
+			return err
+		}
+		defer closeAudit()
+
+		header, key, _, entries, err := adminTarget(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		var commands []string
+		if key != "" {
+			commands = append(commands, exporter.SetCommand(header.Name, key, data))
+		} else {
+			for _, e := range entries {
+				commands = append(commands, exporter.SetCommand(header.Name, e.Key, data))
+			}
+		}
+		if len(commands) == 0 {
+			fmt.Fprintf(os.Stderr, "No entry of %s matches\n", header.Name)
+			return nil
+		}
+		if key == "" && !adminDryRun && !adminYes {
+			if !confirm(os.Stdin, fmt.Sprintf("Change %d entries of %s?", len(commands), header.Name)) {
+				return fmt.Errorf("Aborted")
+			}
+		}
+
+		return applyCommands(cmd.Context(), audit, commands)
+	},
+}
+
+func init() {
+	addAdminFlags(setCmd)
+	rootCmd.AddCommand(setCmd)
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	exporter "haproxy-table-exporter/pkg"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchInterval time.Duration
+	watchTop      int
+	watchDataType string
+	watchNoColor  bool
+	// watchCmd renders the top keys of a stick-table until interrupted
+	watchCmd = &cobra.Command{
+		Use:   "watch",
+		Short: "Show the top keys of a stick-table, refreshed at an interval",
+		Long: `
+Queries a stick-table at every interval and shows the keys with the highest value of
+a data type, with the change since the previous refresh. New keys are highlighted in
+green and keys which disappeared from the table in red.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkSocket(); err != nil {
+				return err
+			}
+			if minimumRequestRate < 0 {
+				return fmt.Errorf("Invalid value for minRequestRate: %d", minimumRequestRate)
+			}
+			if watchInterval <= 0 {
+				return fmt.Errorf("Invalid value for interval: %s", watchInterval)
+			}
+			if watchTop <= 0 {
+				return fmt.Errorf("Invalid value for top: %d", watchTop)
+			}
+
+			ctx := cmd.Context()
+			// Refreshes reuse a session in interactive mode instead of connecting every time
+			client := newClient()
+			client.Persistent(1)
+			defer client.Close()
+
+			color := !watchNoColor && isTerminal(os.Stdout)
+			topTalkers := exporter.NewTopTalkers(watchDataType, watchTop)
+			ticker := time.NewTicker(watchInterval)
+			defer ticker.Stop()
+			for {
+				header, entries, err := client.ShowTable(ctx, exporter.QueryOptions{
+					Table:        stickTable,
+					DataType:     watchDataType,
+					MinimumValue: minimumRequestRate,
+				})
+				if ctx.Err() != nil {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				rows := topTalkers.Update(entries)
+				if err := exporter.RenderTopTalkers(os.Stdout, header, watchDataType, rows, color, time.Now()); err != nil {
+					return err
+				}
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+				}
+			}
+		},
+	}
+)
+
+// isTerminal reports whether f is a character device, e.g. not redirected to a file
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func init() {
+	watchCmd.Flags().DurationVarP(&watchInterval, "interval", "i", 2*time.Second, "Time between refreshes")
+	watchCmd.Flags().IntVar(&watchTop, "top", 20, "Number of keys to show")
+	watchCmd.Flags().StringVar(&watchDataType, "data-type", "http_req_rate", "Data type to rank the keys by")
+	watchCmd.Flags().BoolVar(&watchNoColor, "no-color", false, "Don't highlight new and gone keys")
+	rootCmd.AddCommand(watchCmd)
+}
@@ -0,0 +1,179 @@
+package exporter
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// commandSeparators separate the arguments and the commands of the runtime API, an argument
+// containing one of them could smuggle another command
+const commandSeparators = " \t\r\n;"
+
+// CheckArgument returns an error when an argument pasted in a command of the runtime API, such as
+// a table name, a data type or a key, is empty or contains spaces or semicolons
+func CheckArgument(name string, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s cannot be empty", name)
+	}
+	if strings.ContainsAny(value, commandSeparators) {
+		return fmt.Errorf("Invalid %s '%s', it can't contain spaces or semicolons", name, value)
+	}
+	return nil
+}
+
+// Filter selects the entries of a stick-table on the value of a data type, e.g. gpc0 gt 0
+type Filter struct {
+	DataType string
+	// Operator is one of eq, ne, le, lt, ge or gt
+	Operator string
+	Value    int
+}
+
+// ParseFilter parses a filter written as "<data type> <operator> <value>", e.g. "gpc0 gt 0"
+func ParseFilter(s string) (Filter, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return Filter{}, fmt.Errorf("Invalid filter '%s', expected '<data type> <operator> <value>'", s)
+	}
+	switch fields[1] {
+	case "eq", "ne", "le", "lt", "ge", "gt":
+	default:
+		return Filter{}, fmt.Errorf("Invalid operator '%s', expected one of eq, ne, le, lt, ge or gt", fields[1])
+	}
+	value, err := strconv.Atoi(fields[2])
+	if err != nil || value < 0 {
+		return Filter{}, fmt.Errorf("Invalid value '%s', expected a positive integer", fields[2])
+	}
+	dataType := strings.TrimPrefix(fields[0], "data.")
+	if err := CheckArgument("data type", dataType); err != nil {
+		return Filter{}, err
+	}
+
+	return Filter{DataType: dataType, Operator: fields[1], Value: value}, nil
+}
+
+// QueryOptions returns the options of Client.ShowTable selecting the entries matching the filter
+func (f Filter) QueryOptions(table string) QueryOptions {
+	return QueryOptions{Table: table, DataType: f.DataType, Operator: f.Operator, MinimumValue: f.Value}
+}
+
+// ParseKey checks that a key is valid for the type of a stick-table and returns it
+// in the form HAProxy expects.
+func ParseKey(tableType string, key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("key cannot be empty")
+	}
+	switch tableType {
+	case "ip", "ipv6":
+		addr, err := netip.ParseAddr(key)
+		if err != nil {
+			return "", fmt.Errorf("Invalid key '%s' for a table of type %s: %v", key, tableType, err)
+		}
+		return addr.String(), nil
+	case "integer":
+		if _, err := strconv.ParseUint(key, 10, 32); err != nil {
+			return "", fmt.Errorf("Invalid key '%s' for a table of type integer", key)
+		}
+	case "binary":
+		if _, err := hex.DecodeString(key); err != nil {
+			return "", fmt.Errorf("Invalid key '%s' for a table of type binary, expected hexadecimal", key)
+		}
+	case "string":
+		if strings.ContainsAny(key, " \t\n;") {
+			return "", fmt.Errorf("Invalid key '%s', keys can't contain spaces or semicolons", key)
+		}
+	default:
+		return "", fmt.Errorf("%w '%s'", ErrUnsupportedTableType, tableType)
+	}
+
+	return key, nil
+}
+
+// ParseDataAssignments parses the data types to set on an entry, written as <data type>=<value>, e.g. gpc0=1
+func ParseDataAssignments(args []string) ([]DataValue, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("At least one <data type>=<value> is required")
+	}
+	var data []DataValue
+	for _, arg := range args {
+		name, v, found := strings.Cut(arg, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("Invalid data '%s', expected <data type>=<value>", arg)
+		}
+		value, err := strconv.Atoi(v)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("Invalid value '%s' for %s, expected a positive integer", v, name)
+		}
+		name = strings.TrimPrefix(name, "data.")
+		if err := CheckArgument("data type", name); err != nil {
+			return nil, err
+		}
+		data = append(data, DataValue{Name: name, Value: value})
+	}
+
+	return data, nil
+}
+
+// ClearCommand returns the command removing a single key of a table, the entries matching
+// a filter when key is empty, or all the entries when filter is nil too.
+func ClearCommand(table string, key string, filter *Filter) string {
+	switch {
+	case key != "":
+		return fmt.Sprintf("clear table %s key %s", table, key)
+	case filter != nil:
+		return fmt.Sprintf("clear table %s data.%s %s %d", table, filter.DataType, filter.Operator, filter.Value)
+	default:
+		return fmt.Sprintf("clear table %s", table)
+	}
+}
+
+// SetCommand returns the command setting data types of a key, creating the entry when needed
+func SetCommand(table string, key string, data []DataValue) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "set table %s key %s", table, key)
+	for _, d := range data {
+		fmt.Fprintf(&b, " data.%s %d", d.Name, d.Value)
+	}
+	return b.String()
+}
+
+// ExecAdminCommand sends a command changing the state of HAProxy and records it in the audit logger.
+// HAProxy answers these commands with an empty line, anything else is the reason of a failure.
+func ExecAdminCommand(ctx context.Context, client *Client, audit *slog.Logger, cmd string) error {
+	// The commands are built from checked arguments, a separator means another command was smuggled in
+	if strings.ContainsAny(cmd, ";\r\n") {
+		err := fmt.Errorf("Refusing to send '%s', it holds several commands", cmd)
+		audit.Error("Runtime command refused", "command", cmd, "socket", client.Socket, "user", currentUser(), "error", err)
+		return err
+	}
+	response, err := client.ExecRaw(ctx, cmd)
+	if r := trimResponse(response); err == nil && r != "" {
+		if err = runtimeError(cmd, r); err == nil {
+			err = &RuntimeError{Command: cmd, Message: r}
+		}
+	}
+
+	attrs := []any{"command", cmd, "socket", client.Socket, "user", currentUser()}
+	if err != nil {
+		audit.Error("Runtime command failed", append(attrs, "error", err)...)
+		return err
+	}
+	audit.Info("Runtime command applied", attrs...)
+
+	return nil
+}
+
+// currentUser returns the name of the user running the process, for the audit log
+func currentUser() string {
+	u, err := user.Current()
+	if err != nil {
+		return "unknown"
+	}
+	return u.Username
+}
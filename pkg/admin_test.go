@@ -0,0 +1,117 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_ParseKey(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		tableType   string
+		key         string
+		expected    string
+		expectedErr string
+	}{
+		{name: "ipv4", tableType: "ip", key: "10.0.0.1", expected: "10.0.0.1"},
+		{name: "ipv6 is normalized", tableType: "ipv6", key: "2001:DB8:0::1", expected: "2001:db8::1"},
+		{name: "invalid ip", tableType: "ip", key: "10.0.0", expectedErr: "Invalid key"},
+		{name: "integer", tableType: "integer", key: "42", expected: "42"},
+		{name: "negative integer", tableType: "integer", key: "-1", expectedErr: "Invalid key"},
+		{name: "binary", tableType: "binary", key: "deadbeef", expected: "deadbeef"},
+		{name: "string with a semicolon", tableType: "string", key: "a;show info", expectedErr: "Invalid key"},
+		{name: "unknown type", tableType: "foo", key: "a", expectedErr: "Unsupported table type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey(tt.tableType, tt.key)
+			if (tt.expectedErr != "") != (err != nil) {
+				t.Fatalf("errored = %v, expected error %q", err, tt.expectedErr)
+			}
+			if err != nil && !strings.HasPrefix(err.Error(), tt.expectedErr) {
+				t.Errorf("error message  --%v--, want something which starts with --%v--", err.Error(), tt.expectedErr)
+			}
+			if key != tt.expected {
+				t.Errorf("ParseKey() = %q, want %q", key, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_adminCommands(t *testing.T) {
+	t.Parallel()
+	filter, err := ParseFilter("data.gpc0 gt 0")
+	if err != nil {
+		t.Fatalf("ParseFilter() errored: %v", err)
+	}
+	if _, err := ParseFilter("gpc0 > 0"); err == nil {
+		t.Errorf("ParseFilter() accepted an invalid operator")
+	}
+	data, err := ParseDataAssignments([]string{"gpc0=1", "data.gpt0=3"})
+	if err != nil {
+		t.Fatalf("ParseDataAssignments() errored: %v", err)
+	}
+	if _, err := ParseDataAssignments([]string{"gpc0"}); err == nil {
+		t.Errorf("ParseDataAssignments() accepted a data type without value")
+	}
+
+	got := []string{
+		ClearCommand("t", "10.0.0.1", nil),
+		ClearCommand("t", "", &filter),
+		ClearCommand("t", "", nil),
+		SetCommand("t", "10.0.0.1", data),
+	}
+	expected := []string{
+		"clear table t key 10.0.0.1",
+		"clear table t data.gpc0 gt 0",
+		"clear table t",
+		"set table t key 10.0.0.1 data.gpc0 1 data.gpt0 3",
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func Test_smuggledCommands(t *testing.T) {
+	t.Parallel()
+	if _, err := ParseFilter("gpc0;clear gt 0"); err == nil {
+		t.Error("ParseFilter() accepted a data type with a semicolon")
+	}
+	if _, err := ParseDataAssignments([]string{"gpc0;clear=1"}); err == nil {
+		t.Error("ParseDataAssignments() accepted a data type with a semicolon")
+	}
+
+	// The options are checked before connecting to the socket
+	client := NewClient(filepath.Join(t.TempDir(), "missing.sock"), Timeouts{}, testLogger)
+	for _, opts := range []QueryOptions{
+		{Table: "t", Key: "1.2.3.4;clear table t"},
+		{Table: "t;clear table t"},
+		{Table: "t", DataType: "gpc0 gt 0;clear table t"},
+	} {
+		_, _, err := client.ShowTable(context.Background(), opts)
+		if err == nil || !strings.HasPrefix(err.Error(), "Invalid ") {
+			t.Errorf("ShowTable(%+v) error = %v, want an invalid argument", opts, err)
+		}
+	}
+	for _, backend := range []string{"be_app;clear table t", "be_app\nclear table t"} {
+		if _, err := client.ShowServersState(context.Background(), backend); err == nil || !strings.HasPrefix(err.Error(), "Invalid ") {
+			t.Errorf("ShowServersState(%q) error = %v, want an invalid argument", backend, err)
+		}
+	}
+
+	var audit bytes.Buffer
+	err := ExecAdminCommand(context.Background(), client, slog.New(slog.NewJSONHandler(&audit, nil)), "clear table t key 1.2.3.4;clear table t")
+	if err == nil || !strings.HasPrefix(err.Error(), "Refusing") {
+		t.Errorf("ExecAdminCommand() error = %v, want a refusal", err)
+	}
+	if !strings.Contains(audit.String(), "Runtime command refused") {
+		t.Errorf("audit log = %s, want the refusal", audit.String())
+	}
+}
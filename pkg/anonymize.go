@@ -0,0 +1,199 @@
+package exporter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Modes of an Anonymizer
+const (
+	// AnonymizeHMAC replaces the keys with a hash keyed by a secret, which the lookup command
+	// reverses by hashing the candidate keys with the same secret
+	AnonymizeHMAC = "hmac"
+	// AnonymizeTruncate zeroes the last octet of IPv4 addresses and keeps the /48 network of
+	// IPv6 addresses, it can't be reversed
+	AnonymizeTruncate = "truncate"
+)
+
+const (
+	// MinSecretSize is the minimum size in bytes of the secret of AnonymizeHMAC
+	MinSecretSize = 16
+	// hashSize is the number of bytes of the HMAC kept in the anonymized keys
+	hashSize = 8
+	// maxLookupBits bounds the size of a network searched by LookupNetwork to 2^maxLookupBits addresses
+	maxLookupBits = 24
+)
+
+// Anonymizer replaces the client IP addresses of the entries before they are exported by any
+// backend. The values of the stick-table are still compared to the thresholds per address.
+// A nil Anonymizer keeps the addresses.
+type Anonymizer struct {
+	// Mode is AnonymizeHMAC or AnonymizeTruncate
+	Mode string
+	// Secret keys the HMAC, at least MinSecretSize bytes
+	Secret []byte
+}
+
+// NewAnonymizer returns an anonymizer, the secret is only used by AnonymizeHMAC
+func NewAnonymizer(mode string, secret []byte) (*Anonymizer, error) {
+	switch mode {
+	case AnonymizeHMAC:
+		if len(secret) < MinSecretSize {
+			return nil, fmt.Errorf("The HMAC secret must be at least %d bytes long, got %d", MinSecretSize, len(secret))
+		}
+	case AnonymizeTruncate:
+	default:
+		return nil, fmt.Errorf("Unsupported anonymization mode '%s'", mode)
+	}
+	return &Anonymizer{Mode: mode, Secret: secret}, nil
+}
+
+// ReadSecret reads the secret of the HMAC from a file, without its surrounding whitespace
+func ReadSecret(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(secret), nil
+}
+
+// Key returns the anonymized key of an address
+func (a *Anonymizer) Key(ip netip.Addr) string {
+	if a == nil {
+		return ip.String()
+	}
+	switch a.Mode {
+	case AnonymizeHMAC:
+		return a.hash(ip.String())
+	case AnonymizeTruncate:
+		return truncateAddr(ip).String()
+	}
+	return ip.String()
+}
+
+// EntryKey returns the anonymized key of an entry
+func (a *Anonymizer) EntryKey(key string) string {
+	if a == nil {
+		return key
+	}
+	if ip, err := netip.ParseAddr(key); err == nil {
+		return a.Key(ip)
+	}
+	// The exported entries are addresses, a key which isn't one is never sent in clear
+	return a.hash(key)
+}
+
+// Prefix returns the anonymized network of a prefix. A truncated prefix is at most as long as
+// the truncated addresses.
+func (a *Anonymizer) Prefix(p netip.Prefix) string {
+	if a == nil {
+		return p.String()
+	}
+	switch a.Mode {
+	case AnonymizeHMAC:
+		return a.hash(p.String())
+	case AnonymizeTruncate:
+		if bits := truncateBits(p.Addr()); p.Bits() > bits {
+			p, _ = p.Addr().Prefix(bits)
+		}
+	}
+	return p.String()
+}
+
+// lineKeyRegex matches the key of an entry in a line of a "show table" response
+var lineKeyRegex = regexp.MustCompile(`\bkey=(\S+)`)
+
+// Line returns a line of a "show table" response with its key anonymized, or an empty string
+// when the line has no key, as it could hold an address anywhere
+func (a *Anonymizer) Line(line string) string {
+	if a == nil {
+		return line
+	}
+	m := lineKeyRegex.FindStringSubmatchIndex(line)
+	if m == nil {
+		return ""
+	}
+	return line[:m[2]] + a.EntryKey(line[m[2]:m[3]]) + line[m[3]:]
+}
+
+// redact returns a ParseError with the key of its line anonymized, other errors are returned
+// as is. The reasons of a ParseError never hold the key, only its line does.
+func (a *Anonymizer) redact(err error) error {
+	var parseErr *ParseError
+	if a == nil || !errors.As(err, &parseErr) {
+		return err
+	}
+	return &ParseError{Line: parseErr.Line, Raw: a.Line(parseErr.Raw), Err: parseErr.Err}
+}
+
+// hash returns the truncated HMAC-SHA256 of s in hexadecimal
+func (a *Anonymizer) hash(s string) string {
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil)[:hashSize])
+}
+
+// truncateBits returns the number of bits kept by AnonymizeTruncate
+func truncateBits(ip netip.Addr) int {
+	if ip.Is4() {
+		return 24
+	}
+	return 48
+}
+
+// truncateAddr returns the network address of the truncated address
+func truncateAddr(ip netip.Addr) netip.Addr {
+	p, _ := ip.Prefix(truncateBits(ip))
+	return p.Addr()
+}
+
+// Lookup returns the candidate keys whose HMAC is hash
+func (a *Anonymizer) Lookup(hash string, candidates []string) ([]string, error) {
+	if err := a.checkReversible(); err != nil {
+		return nil, err
+	}
+	hash = strings.ToLower(hash)
+	var found []string
+	for _, key := range candidates {
+		if a.EntryKey(key) == hash {
+			found = append(found, key)
+		}
+	}
+	return found, nil
+}
+
+// LookupNetwork returns the addresses of a network whose HMAC is hash, the network is searched
+// exhaustively so it is limited in size
+func (a *Anonymizer) LookupNetwork(hash string, network netip.Prefix) ([]netip.Addr, error) {
+	if err := a.checkReversible(); err != nil {
+		return nil, err
+	}
+	network = network.Masked()
+	if network.Addr().BitLen()-network.Bits() > maxLookupBits {
+		return nil, fmt.Errorf("Network %s is too large to search, at most 2^%d addresses are supported", network, maxLookupBits)
+	}
+	hash = strings.ToLower(hash)
+	var found []netip.Addr
+	for ip := network.Addr(); ip.IsValid() && network.Contains(ip); ip = ip.Next() {
+		if a.hash(ip.String()) == hash {
+			found = append(found, ip)
+		}
+	}
+	return found, nil
+}
+
+// checkReversible returns an error unless the keys are hashed
+func (a *Anonymizer) checkReversible() error {
+	if a == nil || a.Mode != AnonymizeHMAC {
+		return fmt.Errorf("Only keys anonymized with %s can be looked up", AnonymizeHMAC)
+	}
+	return nil
+}
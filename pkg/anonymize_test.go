@@ -0,0 +1,190 @@
+package exporter
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testSecret is the secret of the HMAC in the tests
+var testSecret = []byte("0123456789abcdef")
+
+func Test_Anonymizer(t *testing.T) {
+	t.Parallel()
+	hmacAnonymizer, err := NewAnonymizer(AnonymizeHMAC, testSecret)
+	if err != nil {
+		t.Fatalf("NewAnonymizer() errored: %v", err)
+	}
+	truncateAnonymizer, err := NewAnonymizer(AnonymizeTruncate, nil)
+	if err != nil {
+		t.Fatalf("NewAnonymizer() errored: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		anonymizer *Anonymizer
+		key        string
+		prefix     string
+		expected   string
+		// expectedPrefix is the anonymized prefix
+		expectedPrefix string
+	}{
+		{name: "nil ipv4", key: "1.32.20.122", prefix: "1.32.20.0/24", expected: "1.32.20.122", expectedPrefix: "1.32.20.0/24"},
+		{name: "hmac ipv4", anonymizer: hmacAnonymizer, key: "1.32.20.122", prefix: "1.32.20.0/24", expected: "82f65fa7c7af030f", expectedPrefix: "b58c619d7af09c44"},
+		{name: "truncate ipv4", anonymizer: truncateAnonymizer, key: "1.32.20.122", prefix: "1.32.20.0/24", expected: "1.32.20.0", expectedPrefix: "1.32.20.0/24"},
+		{name: "truncate ipv6", anonymizer: truncateAnonymizer, key: "2001:db8:1:2::1", prefix: "2001:db8:1:2::/64", expected: "2001:db8:1::", expectedPrefix: "2001:db8:1::/48"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.anonymizer.Key(netip.MustParseAddr(tt.key)); got != tt.expected {
+				t.Errorf("Key() = %s, want %s", got, tt.expected)
+			}
+			if got := tt.anonymizer.EntryKey(tt.key); got != tt.expected {
+				t.Errorf("EntryKey() = %s, want %s", got, tt.expected)
+			}
+			if got := tt.anonymizer.Prefix(netip.MustParsePrefix(tt.prefix)); got != tt.expectedPrefix {
+				t.Errorf("Prefix() = %s, want %s", got, tt.expectedPrefix)
+			}
+		})
+	}
+
+	if _, err := NewAnonymizer(AnonymizeHMAC, []byte("short")); err == nil {
+		t.Error("NewAnonymizer() with a short secret didn't error")
+	}
+	if _, err := NewAnonymizer("rot13", nil); err == nil {
+		t.Error("NewAnonymizer() with an unknown mode didn't error")
+	}
+}
+
+func Test_AnonymizerLookup(t *testing.T) {
+	t.Parallel()
+	a := &Anonymizer{Mode: AnonymizeHMAC, Secret: testSecret}
+	key := a.Key(netip.MustParseAddr("10.0.3.7"))
+
+	found, err := a.Lookup(strings.ToUpper(key), []string{"10.0.3.6", "10.0.3.7", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("Lookup() errored: %v", err)
+	}
+	if diff := cmp.Diff([]string{"10.0.3.7"}, found); diff != "" {
+		t.Errorf("Lookup() mismatch (-want +got):\n%s", diff)
+	}
+
+	ips, err := a.LookupNetwork(key, netip.MustParsePrefix("10.0.0.0/16"))
+	if err != nil {
+		t.Fatalf("LookupNetwork() errored: %v", err)
+	}
+	if diff := cmp.Diff([]netip.Addr{netip.MustParseAddr("10.0.3.7")}, ips, cmp.Comparer(func(a, b netip.Addr) bool { return a == b })); diff != "" {
+		t.Errorf("LookupNetwork() mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := a.LookupNetwork(key, netip.MustParsePrefix("10.0.0.0/7")); err == nil {
+		t.Error("LookupNetwork() of a too large network didn't error")
+	}
+	if _, err := (&Anonymizer{Mode: AnonymizeTruncate}).Lookup(key, nil); err == nil {
+		t.Error("Lookup() of truncated addresses didn't error")
+	}
+}
+
+func Test_SetAnonymizer(t *testing.T) {
+	t.Parallel()
+	e := NewStickTableExporter(10, testLogger)
+	e.SetAnonymizer(&Anonymizer{Mode: AnonymizeTruncate})
+	e.SetThreshold("t1", 100)
+	e.UpdateData("t1", "http_req_rate", map[netip.Addr]int{
+		netip.MustParseAddr("10.0.0.1"):    60,
+		netip.MustParseAddr("10.0.0.2"):    70,
+		netip.MustParseAddr("2001:db8::1"): 150,
+	})
+
+	// The addresses of a network are summed, the threshold applies to every address
+	expected := `
+# HELP haproxy_stick_table Tracks the value of a data type, e.g. 'http_req_rate', per client IP address as observed by custom stick-table in HAProxy
+# TYPE haproxy_stick_table gauge
+haproxy_stick_table{client_ip="10.0.0.0",data_type="http_req_rate",name="t1",type="ip"} 130
+haproxy_stick_table{client_ip="2001:db8::",data_type="http_req_rate",name="t1",type="ip"} 150
+# HELP haproxy_stick_table_entries_over_threshold Number of entries in a stick-table with a value above the configured threshold
+# TYPE haproxy_stick_table_entries_over_threshold gauge
+haproxy_stick_table_entries_over_threshold{name="t1"} 1
+# HELP haproxy_stick_table_over_threshold_key Value of the entries above the configured threshold, limited to the highest ones
+# TYPE haproxy_stick_table_over_threshold_key gauge
+haproxy_stick_table_over_threshold_key{client_ip="2001:db8::",name="t1"} 150
+`
+	if err := testutil.GatherAndCompare(e.Gatherer(), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	lines, err := StatsDConfig{}.lines(e)
+	if err != nil {
+		t.Fatalf("lines() errored: %v", err)
+	}
+	expectedLines := []string{
+		"haproxy.stick_table.http_req_rate:130|g|#table:t1,type:ip,key:10.0.0.0",
+		"haproxy.stick_table.http_req_rate:150|g|#table:t1,type:ip,key:2001:db8::",
+	}
+	if diff := cmp.Diff(expectedLines, lines[:2]); diff != "" {
+		t.Errorf("lines() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_AnonymizedParseErrors(t *testing.T) {
+	t.Parallel()
+	a := &Anonymizer{Mode: AnonymizeHMAC, Secret: testSecret}
+	response := `# table: t1, type: ip, size:100, used:3
+0x1: key=10.0.3.7 use=0 exp=0 shard=0 http_req_rate(10000)=1
+0x2: key=10.0.3.7 use=0 exp=0 shard=0 http_req_rate(10000)=2
+0x3: key=10.0.3.8 use=0 exp=0 shard=0 gpc0=3
+garbage from 10.0.3.9
+`
+	key := a.Key(netip.MustParseAddr("10.0.3.7"))
+
+	_, _, _, err := parse(testLogger, response, "http_req_rate", ParseStrict, a)
+	if err == nil {
+		t.Fatal("parse() of a duplicate key didn't error")
+	}
+	if got := err.Error(); strings.Contains(got, "10.0.3") || !strings.Contains(got, "key="+key) {
+		t.Errorf("parse() error --%s-- isn't anonymized", got)
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if _, _, _, err := parse(logger, response, "http_req_rate", ParseLenient, a); err != nil {
+		t.Fatalf("parse() errored: %v", err)
+	}
+	if strings.Contains(logs.String(), "10.0.3") || !strings.Contains(logs.String(), "key="+key) {
+		t.Errorf("parse() logs aren't anonymized:\n%s", logs.String())
+	}
+
+	file := filepath.Join(t.TempDir(), "t1.dump")
+	if err := os.WriteFile(file, []byte(response), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(MetricsHandler(Config{
+		Tables:     []Table{{Name: "t1", DataType: "http_req_rate"}},
+		FromFile:   file,
+		Anonymizer: a,
+		Logger:     testLogger,
+	}))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || strings.Contains(string(body), "10.0.3") {
+		t.Errorf("status = %d, body --%s--, want an anonymized error", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,455 @@
+package exporter
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// prompt is written by HAProxy after every response in interactive mode
+const prompt = "\n> "
+
+// Stages of a command bounded by Timeouts
+const (
+	StageDial    = "dial"
+	StageWrite   = "write"
+	StageRead    = "read"
+	StageOverall = "overall"
+)
+
+// Timeouts bound the stages of a command, zero for no bound
+type Timeouts struct {
+	// Dial bounds the time to connect to the socket
+	Dial time.Duration
+	// Write bounds the time to send a command
+	Write time.Duration
+	// Read bounds the time HAProxy can stay silent while sending a response,
+	// the response of a large table takes many reads
+	Read time.Duration
+	// Overall bounds a whole command, on top of the deadline of the context
+	Overall time.Duration
+}
+
+// Validate checks that no timeout is negative
+func (t Timeouts) Validate() error {
+	if t.Dial < 0 || t.Write < 0 || t.Read < 0 || t.Overall < 0 {
+		return fmt.Errorf("timeout argument can't be negative")
+	}
+	return nil
+}
+
+// TimeoutError is returned when a stage of a command exceeds its timeout. A dial timeout
+// usually means HAProxy is down while a read timeout means it is slow to answer.
+type TimeoutError struct {
+	// Stage is StageDial, StageWrite, StageRead or StageOverall
+	Stage string
+	// Timeout is the exceeded timeout, zero when only the deadline of the context of the caller is set
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("%s timeout of %s exceeded", e.Stage, e.Timeout)
+	}
+	return fmt.Sprintf("%s timeout exceeded", e.Stage)
+}
+
+// Client sends commands to the HAProxy runtime API over a UNIX socket.
+// Every call opens its own connection unless Persistent is called, use Interactive
+// to send several commands over one.
+type Client struct {
+	// Socket is the path to the HAProxy UNIX socket
+	Socket string
+	// Timeouts bound the stages of every command
+	Timeouts Timeouts
+	// Logger receives the logs of the client, slog.Default() is used when it is nil
+	Logger *slog.Logger
+	// Retry retries the commands failing on a transient error, within the overall timeout
+	Retry RetryPolicy
+	// attempts counts the attempts of all the commands
+	attempts atomic.Int64
+	// pool holds the sessions of a persistent client, nil when every call opens its own connection
+	pool *Pool
+}
+
+// NewClient returns a client for the socket
+func NewClient(socket string, timeouts Timeouts, logger *slog.Logger) *Client {
+	return &Client{Socket: socket, Timeouts: timeouts, Logger: logger}
+}
+
+// Persistent makes the client send the commands of Exec and the show helpers over up to
+// size sessions in interactive mode, kept open between calls. Close releases them.
+func (c *Client) Persistent(size int) {
+	c.pool = NewPool(c, size)
+}
+
+// Close closes the sessions of a persistent client
+func (c *Client) Close() error {
+	if c.pool == nil {
+		return nil
+	}
+	return c.pool.Close()
+}
+
+// Attempts returns the number of attempts of the commands sent since the client was created, retries included
+func (c *Client) Attempts() int64 {
+	return c.attempts.Load()
+}
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
+}
+
+// Returns a context bounded by the overall timeout of the client
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeouts.Overall > 0 {
+		return context.WithTimeout(ctx, c.Timeouts.Overall)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Returns the error of a stage, a TimeoutError when its timeout or the deadline of ctx was exceeded
+func (c *Client) stageError(ctx context.Context, stage string, timeout time.Duration, err error) error {
+	if !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ctx.Err()
+	}
+	// The connection may time out right before ctx when their deadlines are the same
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return &TimeoutError{Stage: StageOverall, Timeout: c.Timeouts.Overall}
+	}
+	return &TimeoutError{Stage: stage, Timeout: timeout}
+}
+
+// Dial opens a connection to the socket within the dial timeout
+func (c *Client) Dial(ctx context.Context) (net.Conn, error) {
+	if c.Socket == "" {
+		return nil, fmt.Errorf("socket argument cannot be empty")
+	}
+	dialCtx := ctx
+	if c.Timeouts.Dial > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, c.Timeouts.Dial)
+		defer cancel()
+	}
+	var d net.Dialer
+	raddr := net.UnixAddr{Name: c.Socket}
+	conn, err := d.DialContext(dialCtx, "unix", raddr.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w to %s UNIX socket: %w", ErrConnect, c.Socket, c.stageError(ctx, StageDial, c.Timeouts.Dial, err))
+	}
+
+	return conn, nil
+}
+
+// interruptOnCancel interrupts the pending reads and writes of the connection when ctx
+// is canceled. The returned function releases the binding.
+func interruptOnCancel(ctx context.Context, conn net.Conn) func() bool {
+	return context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+}
+
+// setDeadline bounds the next operation on a connection by the timeout of its stage and the deadline of ctx
+func setDeadline(ctx context.Context, set func(time.Time) error, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	if err := set(deadline); err != nil {
+		return err
+	}
+	// The deadline replaced the one set by interruptOnCancel if ctx was canceled in the meantime
+	return ctx.Err()
+}
+
+// write sends a command over the connection within the write timeout
+func (c *Client) write(ctx context.Context, conn net.Conn, cmd string) error {
+	err := setDeadline(ctx, conn.SetWriteDeadline, c.Timeouts.Write)
+	if err == nil {
+		_, err = conn.Write([]byte(cmd + "\n"))
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to send command to socket: %w", c.stageError(ctx, StageWrite, c.Timeouts.Write, err))
+	}
+	return nil
+}
+
+// read reads the next part of a response within the read timeout
+func (c *Client) read(ctx context.Context, conn net.Conn, buf []byte) (int, error) {
+	err := setDeadline(ctx, conn.SetReadDeadline, c.Timeouts.Read)
+	if err != nil {
+		return 0, fmt.Errorf("Error reading from socket: %w", c.stageError(ctx, StageRead, c.Timeouts.Read, err))
+	}
+	n, err := conn.Read(buf)
+	if err != nil && err != io.EOF {
+		return n, fmt.Errorf("Error reading from socket: %w", c.stageError(ctx, StageRead, c.Timeouts.Read, err))
+	}
+	return n, err
+}
+
+// ExecRaw sends a command over a new connection and returns the response as received
+func (c *Client) ExecRaw(ctx context.Context, cmd string) (string, error) {
+	if cmd == "" {
+		return "", fmt.Errorf("cmd argument cannot be empty")
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	return c.retry(ctx, cmd, func() (string, error) { return c.execRaw(ctx, cmd) })
+}
+
+// Sends a command over a new connection and returns the response as received, without retries
+func (c *Client) execRaw(ctx context.Context, cmd string) (string, error) {
+	conn, err := c.Dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	defer interruptOnCancel(ctx, conn)()
+
+	logger := c.logger()
+	logger.Debug("Sending command", "command", cmd)
+	start := time.Now()
+	if err := c.write(ctx, conn, cmd); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 1024)
+	var data strings.Builder
+	for {
+		n, err := c.read(ctx, conn, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		data.Write(buf[0:n])
+	}
+	logger.Debug("Received response", "bytes", data.Len(), "duration", time.Since(start))
+
+	return data.String(), nil
+}
+
+// Exec sends a command over a new connection, or a session of a persistent client, and
+// returns the response without the prompt and the trailing empty line. Error messages of
+// HAProxy, e.g. "No such table", are returned as a RuntimeError.
+func (c *Client) Exec(ctx context.Context, cmd string) (string, error) {
+	var response string
+	var err error
+	if c.pool != nil {
+		ctx, cancel := c.withTimeout(ctx)
+		defer cancel()
+		response, err = c.retry(ctx, cmd, func() (string, error) { return c.pool.Exec(ctx, cmd) })
+	} else {
+		response, err = c.ExecRaw(ctx, cmd)
+		response = trimResponse(response)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := runtimeError(cmd, response); err != nil {
+		return "", err
+	}
+	return response, nil
+}
+
+// Session is a connection in interactive mode, HAProxy keeps it open between commands
+// and ends every response with a prompt. A session isn't safe for concurrent use.
+type Session struct {
+	client *Client
+	conn   net.Conn
+	buf    []byte
+}
+
+// Interactive opens a connection and switches it to interactive mode
+func (c *Client) Interactive(ctx context.Context) (*Session, error) {
+	conn, err := c.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{client: c, conn: conn, buf: make([]byte, 4096)}
+	if _, err := s.Exec(ctx, "prompt"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Exec sends a command and returns the response without the prompt and the trailing empty line
+func (s *Session) Exec(ctx context.Context, cmd string) (string, error) {
+	if cmd == "" {
+		return "", fmt.Errorf("cmd argument cannot be empty")
+	}
+	defer interruptOnCancel(ctx, s.conn)()
+
+	logger := s.client.logger()
+	logger.Debug("Sending command", "command", cmd, "interactive", true)
+	start := time.Now()
+	if err := s.client.write(ctx, s.conn, cmd); err != nil {
+		return "", err
+	}
+
+	var data strings.Builder
+	for {
+		n, err := s.client.read(ctx, s.conn, s.buf)
+		if err == io.EOF {
+			return "", fmt.Errorf("Error reading from socket: connection closed by HAProxy")
+		}
+		if err != nil {
+			return "", err
+		}
+		data.Write(s.buf[0:n])
+		// The response to the prompt command itself may be the bare prompt
+		r := data.String()
+		if strings.HasSuffix(r, prompt) || r == "> " {
+			break
+		}
+	}
+	logger.Debug("Received response", "bytes", data.Len(), "duration", time.Since(start), "interactive", true)
+
+	return trimResponse(data.String()), nil
+}
+
+// Close leaves interactive mode and closes the connection
+func (s *Session) Close() error {
+	s.conn.SetDeadline(time.Now().Add(100 * time.Millisecond))
+	s.conn.Write([]byte("quit\n"))
+	return s.conn.Close()
+}
+
+// ShowTable returns the header and the entries of a stick-table of any type
+func (c *Client) ShowTable(ctx context.Context, opts QueryOptions) (TableHeader, []Entry, error) {
+	if err := opts.validate(); err != nil {
+		return TableHeader{}, nil, err
+	}
+	response, err := c.Exec(ctx, opts.command())
+	if err != nil {
+		return TableHeader{}, nil, err
+	}
+
+	header, err := parseHeader(response)
+	if err != nil {
+		return TableHeader{}, nil, err
+	}
+	if header.Name != opts.Table {
+		return TableHeader{}, nil, fmt.Errorf("%w. Expected '%s', got '%s'", ErrHeaderMismatch, opts.Table, header.Name)
+	}
+	entries, _, err := parseEntries(c.logger().With("table", opts.Table), response, false, nil)
+	if err != nil {
+		return TableHeader{}, nil, err
+	}
+
+	return header, entries, nil
+}
+
+// ShowInfo returns the fields of "show info", e.g. Version and Uptime_sec
+func (c *Client) ShowInfo(ctx context.Context) (map[string]string, error) {
+	response, err := c.Exec(ctx, "show info")
+	if err != nil {
+		return nil, err
+	}
+
+	info := make(map[string]string)
+	for _, line := range strings.Split(response, "\n") {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		info[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if len(info) == 0 {
+		return nil, fmt.Errorf("Failed to parse show info response, got '%s'", response)
+	}
+
+	return info, nil
+}
+
+// ShowStat returns the rows of "show stat", one map of column name to value per proxy or server
+func (c *Client) ShowStat(ctx context.Context) ([]map[string]string, error) {
+	response, err := c.Exec(ctx, "show stat")
+	if err != nil {
+		return nil, err
+	}
+
+	// The first line is the header, e.g. # pxname,svname,qcur,...
+	header, rest, _ := strings.Cut(response, "\n")
+	if !strings.HasPrefix(header, "# ") {
+		return nil, fmt.Errorf("Failed to parse show stat header, got '%s'", header)
+	}
+	columns := strings.Split(strings.TrimSuffix(strings.TrimPrefix(header, "# "), ","), ",")
+
+	r := csv.NewReader(strings.NewReader(rest))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse show stat response: %v", err)
+	}
+
+	return zipRows(columns, records), nil
+}
+
+// ShowServersState returns the rows of "show servers state", for every backend when backend is empty
+func (c *Client) ShowServersState(ctx context.Context, backend string) ([]map[string]string, error) {
+	cmd := "show servers state"
+	if backend != "" {
+		if err := CheckArgument("backend", backend); err != nil {
+			return nil, err
+		}
+		cmd += " " + backend
+	}
+	response, err := c.Exec(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first line is the version of the format and the second one the header, e.g.
+	// 1
+	// # be_id be_name srv_id srv_name srv_addr ...
+	lines := strings.Split(response, "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[1], "# ") {
+		return nil, fmt.Errorf("Failed to parse show servers state response, got '%s'", response)
+	}
+	columns := strings.Fields(strings.TrimPrefix(lines[1], "# "))
+	var records [][]string
+	for _, line := range lines[2:] {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			records = append(records, fields)
+		}
+	}
+
+	return zipRows(columns, records), nil
+}
+
+// zipRows maps the values of every record to the column names, extra values are dropped
+func zipRows(columns []string, records [][]string) []map[string]string {
+	rows := make([]map[string]string, 0, len(records))
+	for _, record := range records {
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
@@ -0,0 +1,251 @@
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// startTestServer serves the responses of a fake HAProxy runtime API on a UNIX socket,
+// including the interactive mode, and returns the path to the socket.
+func startTestServer(t testing.TB, responses map[string]string) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "haproxy.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to create Unix domain socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				interactive := false
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					cmd := strings.TrimSpace(line)
+					switch cmd {
+					case "prompt":
+						interactive = true
+						conn.Write([]byte(prompt))
+						continue
+					case "quit":
+						return
+					case "":
+						// HAProxy answers an empty line with the prompt in interactive mode
+						if interactive {
+							conn.Write([]byte(prompt))
+							continue
+						}
+					}
+					response, ok := responses[cmd]
+					if !ok {
+						response = "Unknown command: '" + cmd + "'\n"
+					}
+					conn.Write([]byte(response + "\n"))
+					if !interactive {
+						return
+					}
+					conn.Write([]byte("> "))
+				}
+			}()
+		}
+	}()
+
+	return socket
+}
+
+var testResponses = map[string]string{
+	"show info":                 "Name: HAProxy\nVersion: 2.8.5-1\nUptime_sec: 4242\n",
+	"show stat":                 "# pxname,svname,qcur,scur,\nfe_main,FRONTEND,,3,\nbe_app,srv1,0,1,\n",
+	"show servers state be_app": "1\n# be_id be_name srv_id srv_name srv_addr\n3 be_app 1 srv1 10.0.0.10\n",
+	"show table table_requests_limiter_src_ip": "# table: table_requests_limiter_src_ip, type: ip, size:1048576, used:1\n" +
+		"0x7f6d48298b70: key=1.32.20.122 use=0 exp=26834 shard=0 http_req_rate(60000)=1\n",
+	"show table missing": "No such table\n",
+	"show table table_requests_limiter_src_ip data.foo gt 0": "Unknown data type\n",
+	"clear table table_requests_limiter_src_ip":              "Permission denied\n",
+}
+
+func Test_Client(t *testing.T) {
+	t.Parallel()
+	socket := startTestServer(t, testResponses)
+	client := NewClient(socket, Timeouts{Overall: 1 * time.Second}, testLogger)
+	ctx := context.Background()
+
+	info, err := client.ShowInfo(ctx)
+	if err != nil {
+		t.Fatalf("ShowInfo() errored: %v", err)
+	}
+	if info["Version"] != "2.8.5-1" || info["Uptime_sec"] != "4242" {
+		t.Errorf("ShowInfo() = %v", info)
+	}
+
+	stat, err := client.ShowStat(ctx)
+	if err != nil {
+		t.Fatalf("ShowStat() errored: %v", err)
+	}
+	expected := []map[string]string{
+		{"pxname": "fe_main", "svname": "FRONTEND", "qcur": "", "scur": "3"},
+		{"pxname": "be_app", "svname": "srv1", "qcur": "0", "scur": "1"},
+	}
+	if diff := cmp.Diff(expected, stat); diff != "" {
+		t.Error(diff)
+	}
+
+	state, err := client.ShowServersState(ctx, "be_app")
+	if err != nil {
+		t.Fatalf("ShowServersState() errored: %v", err)
+	}
+	expected = []map[string]string{
+		{"be_id": "3", "be_name": "be_app", "srv_id": "1", "srv_name": "srv1", "srv_addr": "10.0.0.10"},
+	}
+	if diff := cmp.Diff(expected, state); diff != "" {
+		t.Error(diff)
+	}
+
+	header, entries, err := client.ShowTable(ctx, QueryOptions{Table: "table_requests_limiter_src_ip"})
+	if err != nil {
+		t.Fatalf("ShowTable() errored: %v", err)
+	}
+	if header.Used != 1 || len(entries) != 1 || entries[0].Key != "1.32.20.122" {
+		t.Errorf("ShowTable() = %v, %v", header, entries)
+	}
+}
+
+func Test_ClientRuntimeErrors(t *testing.T) {
+	t.Parallel()
+	socket := startTestServer(t, testResponses)
+	ctx := context.Background()
+
+	tests := []struct {
+		cmd         string
+		expectedErr error
+	}{
+		{cmd: "show table missing", expectedErr: ErrNoSuchTable},
+		{cmd: "show table table_requests_limiter_src_ip data.foo gt 0", expectedErr: ErrUnknownDataType},
+		{cmd: "clear table table_requests_limiter_src_ip", expectedErr: ErrPermissionDenied},
+		{cmd: "show tables", expectedErr: ErrUnknownCommand},
+	}
+	for _, persistent := range []bool{false, true} {
+		client := NewClient(socket, Timeouts{Overall: 1 * time.Second}, testLogger)
+		if persistent {
+			client.Persistent(1)
+			defer client.Close()
+		}
+		for _, tt := range tests {
+			_, err := client.Exec(ctx, tt.cmd)
+			if !errors.Is(err, tt.expectedErr) || !errors.Is(err, ErrRuntimeAPI) {
+				t.Errorf("Exec(%q) with persistent=%v = %v, want %v", tt.cmd, persistent, err, tt.expectedErr)
+			}
+			var runtimeErr *RuntimeError
+			if errors.As(err, &runtimeErr) && runtimeErr.Command != tt.cmd {
+				t.Errorf("Exec(%q) returned an error for command %q", tt.cmd, runtimeErr.Command)
+			}
+		}
+	}
+}
+
+func Test_Session(t *testing.T) {
+	t.Parallel()
+	socket := startTestServer(t, testResponses)
+	client := NewClient(socket, Timeouts{Overall: 1 * time.Second}, testLogger)
+	ctx := context.Background()
+
+	session, err := client.Interactive(ctx)
+	if err != nil {
+		t.Fatalf("Interactive() errored: %v", err)
+	}
+	defer session.Close()
+	for i := 0; i < 3; i++ {
+		response, err := session.Exec(ctx, "show info")
+		if err != nil {
+			t.Fatalf("Exec() errored: %v", err)
+		}
+		if !strings.HasPrefix(response, "Name: HAProxy\n") || strings.HasSuffix(response, ">") {
+			t.Errorf("Exec() = %q", response)
+		}
+	}
+}
+
+func Test_ClientTimeouts(t *testing.T) {
+	t.Parallel()
+	socket := filepath.Join(t.TempDir(), "haproxy.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to create Unix domain socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	// Accept connections and never answer
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name          string
+		timeouts      Timeouts
+		cancelAfter   time.Duration
+		deadline      time.Duration
+		expectedStage string
+	}{
+		{name: "canceled", cancelAfter: 50 * time.Millisecond},
+		{name: "read timeout", timeouts: Timeouts{Read: 50 * time.Millisecond, Overall: 1 * time.Second}, expectedStage: StageRead},
+		{name: "overall timeout", timeouts: Timeouts{Read: 1 * time.Second, Overall: 50 * time.Millisecond}, expectedStage: StageOverall},
+		{name: "deadline of the caller", deadline: 50 * time.Millisecond, expectedStage: StageOverall},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAfter > 0 {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+			if tt.deadline > 0 {
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+
+			start := time.Now()
+			_, err := NewClient(socket, tt.timeouts, testLogger).Exec(ctx, "show info")
+			if err == nil {
+				t.Fatalf("Exec() didn't fail")
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("Exec() returned after %s, expected it to be interrupted", elapsed)
+			}
+			var timeoutErr *TimeoutError
+			switch {
+			case tt.expectedStage == "":
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("Exec() = %v, want context.Canceled", err)
+				}
+			case !errors.As(err, &timeoutErr):
+				t.Errorf("Exec() = %v, want a TimeoutError", err)
+			case timeoutErr.Stage != tt.expectedStage:
+				t.Errorf("Exec() timed out during %s, want %s", timeoutErr.Stage, tt.expectedStage)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package exporter
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCounterRetention is how long the counter of a key which left the table is kept, in case
+// the key comes back
+const DefaultCounterRetention = time.Hour
+
+// IsCumulative reports whether a data type counts events since the entry was created, e.g.
+// http_req_cnt, conn_cnt or bytes_in_cnt, rather than measuring a rate
+func IsCumulative(dataType string) bool {
+	return strings.HasSuffix(dataType, "_cnt")
+}
+
+// CounterValue is the counter of a cumulative data type of a key
+type CounterValue struct {
+	Key      string
+	DataType string
+	// Total only increases, across the expiry and the re-creation of the entry in HAProxy
+	Total int64
+	// Delta is the increase since the previous query
+	Delta int64
+}
+
+// counterKey identifies the counter of a data type of a key
+type counterKey struct {
+	key      string
+	dataType string
+}
+
+// counterState is the counter of a data type of a key between queries
+type counterState struct {
+	// last is the value the last time the key was in the response
+	last  int
+	total int64
+	// seen is when the key was last in the table
+	seen time.Time
+}
+
+// CounterTracker turns the cumulative data types of the entries into counters which keep
+// increasing when an entry expires and is created again in HAProxy, so rate() works on them.
+// It keeps the previous values between the queries of a daemon or a server, and is safe for
+// concurrent use.
+type CounterTracker struct {
+	// Retention is how long the counter of a key which left the table is kept,
+	// DefaultCounterRetention when zero
+	Retention time.Duration
+
+	mu     sync.Mutex
+	tables map[string]map[counterKey]*counterState
+}
+
+// NewCounterTracker returns a tracker without previous values
+func NewCounterTracker(retention time.Duration) *CounterTracker {
+	return &CounterTracker{Retention: retention, tables: make(map[string]map[counterKey]*counterState)}
+}
+
+// Update returns the counters of the cumulative data types of the entries of a table queried at a time.
+// A value lower than the previous one means the entry expired and was created again, it counts from zero.
+// A key missing from a response isn't considered expired, the query filters out the entries with a low
+// rate which are still in the table: when it comes back with a higher value, only the increase counts.
+// On the first query of a table, the counters start at the values of the entries with a zero delta.
+func (t *CounterTracker) Update(table string, at time.Time, entries []Entry) []CounterValue {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	states, seen := t.tables[table]
+	if !seen {
+		states = make(map[counterKey]*counterState)
+		t.tables[table] = states
+	}
+
+	var values []CounterValue
+	present := make(map[counterKey]bool)
+	for _, e := range entries {
+		for _, d := range e.Data {
+			if !IsCumulative(d.Name) {
+				continue
+			}
+			k := counterKey{key: e.Key, dataType: d.Name}
+			present[k] = true
+			state, ok := states[k]
+			var delta int
+			switch {
+			case !ok:
+				state = &counterState{total: int64(d.Value)}
+				states[k] = state
+				// A key created since the previous query counted from zero
+				if seen {
+					delta = d.Value
+				}
+			case d.Value >= state.last:
+				delta = d.Value - state.last
+			default:
+				delta = d.Value
+			}
+			if ok {
+				state.total += int64(delta)
+			}
+			state.last = d.Value
+			state.seen = at
+			values = append(values, CounterValue{Key: e.Key, DataType: d.Name, Total: state.total, Delta: int64(delta)})
+		}
+	}
+
+	retention := t.Retention
+	if retention <= 0 {
+		retention = DefaultCounterRetention
+	}
+	for k, state := range states {
+		// A key missing for longer than the retention counts from zero if it comes back
+		if !present[k] && at.Sub(state.seen) > retention {
+			delete(states, k)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,99 @@
+package exporter
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_CounterTrackerUpdate(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	entry := func(key string, cnt int) Entry {
+		return Entry{Key: key, Data: []DataValue{{Name: "conn_cnt", Value: cnt}, {Name: "conn_rate", Value: 1}}}
+	}
+	// The entries of every query, a minute apart
+	runs := [][]Entry{
+		{entry("10.0.0.1", 10)},
+		{entry("10.0.0.1", 15), entry("10.0.0.2", 3)},
+		// 10.0.0.1 expired and was created again
+		{entry("10.0.0.1", 2), entry("10.0.0.2", 3)},
+		// 10.0.0.2 expired, or was filtered out
+		{entry("10.0.0.1", 4)},
+		// 10.0.0.2 came back within the retention with a lower value, it was created again
+		{entry("10.0.0.1", 4), entry("10.0.0.2", 1)},
+		// 10.0.0.2 left for longer than the retention, its counter is removed
+		{entry("10.0.0.1", 4)},
+		{entry("10.0.0.1", 4)},
+		{entry("10.0.0.1", 4)},
+		{entry("10.0.0.1", 4), entry("10.0.0.2", 5)},
+	}
+	expected := [][]CounterValue{
+		{{Key: "10.0.0.1", DataType: "conn_cnt", Total: 10}},
+		{{Key: "10.0.0.1", DataType: "conn_cnt", Total: 15, Delta: 5}, {Key: "10.0.0.2", DataType: "conn_cnt", Total: 3, Delta: 3}},
+		{{Key: "10.0.0.1", DataType: "conn_cnt", Total: 17, Delta: 2}, {Key: "10.0.0.2", DataType: "conn_cnt", Total: 3}},
+		{{Key: "10.0.0.1", DataType: "conn_cnt", Total: 19, Delta: 2}},
+		{{Key: "10.0.0.1", DataType: "conn_cnt", Total: 19}, {Key: "10.0.0.2", DataType: "conn_cnt", Total: 4, Delta: 1}},
+		{{Key: "10.0.0.1", DataType: "conn_cnt", Total: 19}},
+		{{Key: "10.0.0.1", DataType: "conn_cnt", Total: 19}},
+		{{Key: "10.0.0.1", DataType: "conn_cnt", Total: 19}},
+		{{Key: "10.0.0.1", DataType: "conn_cnt", Total: 19}, {Key: "10.0.0.2", DataType: "conn_cnt", Total: 5, Delta: 5}},
+	}
+
+	tracker := NewCounterTracker(2 * time.Minute)
+	for i, entries := range runs {
+		values := tracker.Update("t1", start.Add(time.Duration(i)*time.Minute), entries)
+		if diff := cmp.Diff(expected[i], values); diff != "" {
+			t.Errorf("Update() of run %d mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+}
+
+func Test_CounterTrackerFilteredKey(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	entry := Entry{Key: "10.0.0.1", Data: []DataValue{{Name: "conn_cnt", Value: 100}}}
+	tracker := NewCounterTracker(0)
+	tracker.Update("t1", start, []Entry{entry})
+	// The rate of the key dropped to the minimum, its entry is filtered out but still in HAProxy
+	tracker.Update("t1", start.Add(time.Minute), nil)
+	entry.Data[0].Value = 105
+	values := tracker.Update("t1", start.Add(2*time.Minute), []Entry{entry})
+
+	expected := []CounterValue{{Key: "10.0.0.1", DataType: "conn_cnt", Total: 105, Delta: 5}}
+	if diff := cmp.Diff(expected, values); diff != "" {
+		t.Errorf("Update() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_CollectCounters(t *testing.T) {
+	t.Parallel()
+	cfg := Config{
+		Tables:   []Table{{Name: "table_requests_limiter_src_ip", DataType: "http_req_rate"}},
+		FromFile: filepath.Join("testdata", "table_requests_limiter_src_ip.dump"),
+		Logger:   testLogger,
+		Counters: NewCounterTracker(0),
+	}
+	// The dump doesn't change, so the counters keep their values with a zero delta
+	for range 2 {
+		e, err := Collect(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("Collect() errored: %v", err)
+		}
+		expected := `
+# HELP haproxy_stick_table_counter_total Value of a cumulative data type, e.g. 'http_req_cnt', per client IP address, which keeps increasing when the entry expires
+# TYPE haproxy_stick_table_counter_total counter
+haproxy_stick_table_counter_total{client_ip="1.32.20.122",data_type="conn_cnt",name="table_requests_limiter_src_ip",type="ip"} 3
+haproxy_stick_table_counter_total{client_ip="1.39.115.67",data_type="conn_cnt",name="table_requests_limiter_src_ip",type="ip"} 9
+haproxy_stick_table_counter_total{client_ip="2001:db8::1",data_type="conn_cnt",name="table_requests_limiter_src_ip",type="ip"} 12
+`
+		if err := testutil.GatherAndCompare(e.Gatherer(), strings.NewReader(expected), "haproxy_stick_table_counter_total"); err != nil {
+			t.Error(err)
+		}
+	}
+}
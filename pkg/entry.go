@@ -0,0 +1,118 @@
+package exporter
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DataValue is the value of a data type stored in a stick-table entry
+type DataValue struct {
+	// Name of the data type, e.g. http_req_rate
+	Name string
+	// Period of a rate in milliseconds, zero for data types which aren't rates
+	Period int
+	Value  int
+}
+
+// Entry is a single entry of a stick-table
+type Entry struct {
+	// Line is the number of the line of the entry in the response, starting at 1
+	Line int
+	// Raw is the line of the entry as received
+	Raw string
+	Key string
+	Use int
+	// Exp is the number of milliseconds before the entry expires
+	Exp   int
+	Shard int
+	Data  []DataValue
+}
+
+// Value returns the value of a data type of the entry
+func (e Entry) Value(dataType string) (int, bool) {
+	for _, d := range e.Data {
+		if d.Name == dataType {
+			return d.Value, true
+		}
+	}
+	return 0, false
+}
+
+var (
+	// Matches an entry of a "show table" response, e.g.
+	// 0x7fcf0c057200: key=127.0.0.1 use=0 exp=58330 shard=0 conn_cnt=3 http_req_rate(60000)=3
+	// shard is only reported by recent HAProxy versions.
+	entryRegex = regexp.MustCompile(
+		`^` +
+			`\s*0x[[:alnum:]]+: ` + // Match the entry start with a hexadecimal address
+			`key=(?P<key>\S+) ` + // Match and capture the key; 1st group
+			`use=(?P<use>[[:digit:]]+) ` + // Match and capture the use count; 2nd group
+			`exp=(?P<exp>[[:digit:]]+)` + // Match and capture the expiration time; 3rd group
+			`(?: shard=(?P<shard>[[:digit:]]+))?` + // Match and capture the shard value; 4th group
+			`(?P<data>(?: [[:alnum:]_]+(?:\([[:digit:]]+\))?=[[:digit:]]+)+)$`, // Match and capture the data types; 5th group
+	)
+	// Matches a single data type, e.g. http_req_rate(60000)=3 or conn_cnt=3
+	dataRegex = regexp.MustCompile(`(?P<storeType>[[:alnum:]_]+)(?:\((?P<period>[[:digit:]]+)\))?=(?P<value>[[:digit:]]+)`)
+)
+
+// Parses the entries of a "show table" response and returns them with the number of skipped lines.
+// The header, empty lines and the lines which don't look like an entry are skipped, so are the
+// entries with a value out of range when lenient is true. The keys in the errors and the logs
+// are anonymized by anonymizer.
+func parseEntries(logger *slog.Logger, response string, lenient bool, anonymizer *Anonymizer) ([]Entry, int, error) {
+	if response == "" {
+		return nil, 0, &ParseError{Err: errEmptyResponse}
+	}
+
+	var entries []Entry
+	skipped := 0
+	sample := ""
+	lines := strings.Split(response, "\n")
+lines:
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == "" {
+			continue
+		}
+		m := entryRegex.FindStringSubmatch(lines[i])
+		if m == nil {
+			skipped++
+			if sample == "" {
+				sample = anonymizer.Line(lines[i])
+			}
+			continue
+		}
+
+		// The regex guarantees that use, exp and shard are numbers, only overflows can fail
+		entry := Entry{Line: i + 1, Raw: lines[i], Key: m[1]}
+		entry.Use, _ = strconv.Atoi(m[2])
+		entry.Exp, _ = strconv.Atoi(m[3])
+		if m[4] != "" {
+			entry.Shard, _ = strconv.Atoi(m[4])
+		}
+		for _, dm := range dataRegex.FindAllStringSubmatch(m[5], -1) {
+			value, err := strconv.Atoi(dm[3])
+			if err != nil {
+				if lenient {
+					skipped++
+					continue lines
+				}
+				return nil, 0, anonymizer.redact(&ParseError{Line: i + 1, Raw: lines[i], Err: fmt.Errorf("Failed to parse rate: %v", err)})
+			}
+			d := DataValue{Name: dm[1], Value: value}
+			if dm[2] != "" {
+				d.Period, _ = strconv.Atoi(dm[2])
+			}
+			entry.Data = append(entry.Data, d)
+		}
+		entries = append(entries, entry)
+	}
+	if skipped > 0 {
+		logger.Debug("Skipped lines not matching the entry format", "lines", skipped, "sample", sample)
+	}
+	logger.Debug("Parsed response", "entries", len(entries))
+
+	return entries, skipped, nil
+}
@@ -0,0 +1,146 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by the exporter, wrapped with the details of the failure. Callers classify
+// failures with errors.Is, or errors.As for TimeoutError and ParseError.
+var (
+	// ErrConnect is returned when the socket can't be reached
+	ErrConnect = errors.New("Failed to connect")
+	// ErrTimeout is returned when a command takes too long, a TimeoutError tells which stage
+	ErrTimeout = errors.New("Timed out")
+	// ErrHeaderMismatch is returned when the header of a response is for another table
+	ErrHeaderMismatch = errors.New("Table name mismatch")
+	// ErrUnsupportedTableType is returned for a table whose type of key isn't supported
+	ErrUnsupportedTableType = errors.New("Unsupported table type")
+	// ErrParse is returned when a response can't be parsed, a ParseError tells which line
+	ErrParse = errors.New("Failed to parse response")
+	// ErrStoreTypeMismatch is returned when an entry doesn't store the expected data type
+	ErrStoreTypeMismatch = errors.New("Store type mismatch")
+	// ErrDuplicateKey is returned when a key is found twice in a table
+	ErrDuplicateKey = errors.New("Duplicate key detected")
+	// ErrRuntimeAPI is returned when HAProxy answers a command with an error, a RuntimeError holds its message
+	ErrRuntimeAPI = errors.New("HAProxy refused the command")
+	// ErrNoSuchTable is returned when HAProxy has no table of the given name
+	ErrNoSuchTable = errors.New("No such table")
+	// ErrUnknownCommand is returned when HAProxy doesn't know a command, e.g. on old versions
+	ErrUnknownCommand = errors.New("Unknown command")
+	// ErrPermissionDenied is returned when the level of the socket doesn't allow a command,
+	// e.g. clear and set need the admin level
+	ErrPermissionDenied = errors.New("Permission denied")
+	// ErrUnknownDataType is returned when a data type isn't known or isn't stored in the table
+	ErrUnknownDataType = errors.New("Unknown data type")
+)
+
+// runtimeErrors maps the beginning of the error messages of HAProxy to their error
+var runtimeErrors = []struct {
+	prefix string
+	err    error
+}{
+	{prefix: "No such table", err: ErrNoSuchTable},
+	{prefix: "Unknown command", err: ErrUnknownCommand},
+	{prefix: "Permission denied", err: ErrPermissionDenied},
+	{prefix: "Unknown data type", err: ErrUnknownDataType},
+	{prefix: "Data type not stored in this table", err: ErrUnknownDataType},
+}
+
+// RuntimeError is an error message HAProxy answered a command with. It matches ErrRuntimeAPI
+// and, when the message is a known one, a specific error such as ErrNoSuchTable.
+type RuntimeError struct {
+	// Command is the command HAProxy refused
+	Command string
+	// Message is the first line of the answer of HAProxy
+	Message string
+	// Err is the specific error of the message, nil when the message isn't a known one
+	Err error
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("HAProxy refused '%s': %s", e.Command, e.Message)
+}
+
+func (e *RuntimeError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrRuntimeAPI}
+	}
+	return []error{ErrRuntimeAPI, e.Err}
+}
+
+// runtimeError returns the error HAProxy answered a command with, nil when the response isn't a known error message
+func runtimeError(cmd string, response string) error {
+	line, _, _ := strings.Cut(response, "\n")
+	for _, e := range runtimeErrors {
+		if strings.HasPrefix(line, e.prefix) {
+			return &RuntimeError{Command: cmd, Message: strings.TrimSpace(line), Err: e.err}
+		}
+	}
+	return nil
+}
+
+// Is makes a TimeoutError match ErrTimeout
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// ParseError is a response, or a line of a response, which can't be parsed. It matches ErrParse
+// and the reason of the failure.
+type ParseError struct {
+	// Line is the number of the line in the response starting at 1, zero when the whole response is at fault
+	Line int
+	// Raw is the line as received, with its key anonymized when an Anonymizer is configured.
+	// It is empty when the line can't be shown.
+	Raw string
+	// Err is the reason of the failure
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	if e.Raw == "" {
+		return fmt.Sprintf("%v at line %d", e.Err, e.Line)
+	}
+	return fmt.Sprintf("%v at line %d: '%s'", e.Err, e.Line, e.Raw)
+}
+
+func (e *ParseError) Unwrap() []error {
+	return []error{ErrParse, e.Err}
+}
+
+// errEmptyResponse is the reason of the ParseError of an empty response
+var errEmptyResponse = errors.New("Response is empty or malformed")
+
+// ErrorClass returns a short name for the kind of a failure, to label logs and metrics:
+// connect, timeout, no_such_table, unknown_command, permission_denied, unknown_data_type,
+// runtime_api, header_mismatch, unsupported_table_type, parse or other.
+func ErrorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrNoSuchTable):
+		return "no_such_table"
+	case errors.Is(err, ErrUnknownCommand):
+		return "unknown_command"
+	case errors.Is(err, ErrPermissionDenied):
+		return "permission_denied"
+	case errors.Is(err, ErrUnknownDataType):
+		return "unknown_data_type"
+	case errors.Is(err, ErrRuntimeAPI):
+		return "runtime_api"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrConnect):
+		return "connect"
+	case errors.Is(err, ErrHeaderMismatch):
+		return "header_mismatch"
+	case errors.Is(err, ErrUnsupportedTableType):
+		return "unsupported_table_type"
+	case errors.Is(err, ErrParse):
+		return "parse"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,30 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func Test_ErrorClass(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{err: fmt.Errorf("%w to /run/haproxy.sock UNIX socket: %w", ErrConnect, &TimeoutError{Stage: StageDial}), expected: "timeout"},
+		{err: fmt.Errorf("%w to /run/haproxy.sock UNIX socket: connection refused", ErrConnect), expected: "connect"},
+		{err: fmt.Errorf("Error reading from socket: %w", &TimeoutError{Stage: StageRead}), expected: "timeout"},
+		{err: fmt.Errorf("%w. Expected 'a', got 'b'", ErrHeaderMismatch), expected: "header_mismatch"},
+		{err: fmt.Errorf("%w 'string'", ErrUnsupportedTableType), expected: "unsupported_table_type"},
+		{err: &ParseError{Line: 3, Raw: "0x1: key=1.2.3 use=0 exp=0 gpc0=1", Err: ErrDuplicateKey}, expected: "parse"},
+		{err: &RuntimeError{Command: "show table missing", Message: "No such table", Err: ErrNoSuchTable}, expected: "no_such_table"},
+		{err: &RuntimeError{Command: "set table t key k data.gpc0 1", Message: "Invalid key"}, expected: "runtime_api"},
+		{err: errors.New("table argument cannot be empty"), expected: "other"},
+	}
+	for _, tt := range tests {
+		if got := ErrorClass(tt.err); got != tt.expected {
+			t.Errorf("ErrorClass(%v) = %s, want %s", tt.err, got, tt.expected)
+		}
+	}
+}
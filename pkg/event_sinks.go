@@ -0,0 +1,220 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyslogSink sends the events as RFC 5424 messages, by default to the local syslog daemon. The
+// details of an event are in a structured data element when SDID is set.
+type SyslogSink struct {
+	// Network is unixgram, unix, udp or tcp, unixgram when empty. Messages are framed by
+	// octet counting on unix and tcp.
+	Network string
+	// Address is the path of the socket or the host:port of the server, /dev/log when empty
+	Address string
+	// Hostname is the HOSTNAME field of the messages, "-" when empty
+	Hostname string
+	// Facility is the syslog facility of the messages, local0 (16) when zero
+	Facility int
+	// SDID is the ID of the structured data element holding the details of an event, a name
+	// followed by the private enterprise number of its owner, e.g. stickTable@<number>. The
+	// messages have no structured data when empty.
+	SDID string
+}
+
+// sdIDRegex matches the SD-ID of an element which isn't registered with IANA, RFC 5424 6.3.2
+var sdIDRegex = regexp.MustCompile(`^[!#-<>-?A-\\^-~]+@[0-9]+(?:\.[0-9]+)*$`)
+
+// Validate checks that the SD-ID is empty or a name followed by a private enterprise number
+func (s SyslogSink) Validate() error {
+	if s.SDID != "" && (len(s.SDID) > 32 || !sdIDRegex.MatchString(s.SDID)) {
+		return fmt.Errorf("Invalid syslog SD-ID '%s', expected up to 32 characters such as name@<private enterprise number>", s.SDID)
+	}
+	return nil
+}
+
+// syslogAppName is the APP-NAME field of the messages
+const syslogAppName = "haproxy-table-exporter"
+
+// Send writes a message per event
+func (s SyslogSink) Send(ctx context.Context, events []Event) error {
+	network := s.Network
+	if network == "" {
+		network = "unixgram"
+	}
+	address := s.Address
+	if address == "" {
+		address = "/dev/log"
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to syslog %s: %v", address, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+
+	stream := network == "unix" || network == "tcp"
+	for _, e := range events {
+		msg := s.format(e)
+		if stream {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return fmt.Errorf("Failed to write to syslog %s: %v", address, err)
+		}
+	}
+	return nil
+}
+
+// format returns the RFC 5424 message of an event: warning when a key goes over the
+// threshold and notice when it drops back under it
+func (s SyslogSink) format(e Event) string {
+	facility := s.Facility
+	if facility == 0 {
+		facility = 16
+	}
+	severity := 5
+	if e.Kind == EventOverThreshold {
+		severity = 4
+	}
+	hostname := s.Hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	params := []string{
+		"table", e.Table,
+		"key", e.Key,
+		"data_type", e.DataType,
+		"value", strconv.Itoa(e.Value),
+		"threshold", strconv.Itoa(e.Threshold),
+		"peak", strconv.Itoa(e.Peak),
+		"since", e.Since.UTC().Format(time.RFC3339Nano),
+	}
+	if e.Kind == EventUnderThreshold {
+		params = append(params, "duration_seconds", strconv.FormatFloat(e.Duration.Seconds(), 'f', -1, 64))
+	}
+	var sd strings.Builder
+	if s.SDID == "" {
+		sd.WriteString("-")
+	} else {
+		sd.WriteString("[" + s.SDID)
+		for i := 0; i < len(params); i += 2 {
+			fmt.Fprintf(&sd, " %s=\"%s\"", params[i], sdEscaper.Replace(params[i+1]))
+		}
+		sd.WriteString("]")
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		facility*8+severity, e.Time.UTC().Format(time.RFC3339Nano), hostname, syslogAppName, os.Getpid(), e.Kind, sd.String(), e.Message())
+}
+
+// sdEscaper escapes the characters RFC 5424 doesn't allow in the values of structured data
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// LokiSink pushes the events to the push API of Loki, in a stream per table and kind of event.
+// The lines are the events in JSON.
+type LokiSink struct {
+	// URL is the address of Loki, the events are pushed to URL/loki/api/v1/push
+	URL string
+	// Labels are added to the labels of the streams, e.g. the host
+	Labels map[string]string
+	// Headers are sent with every push, e.g. X-Scope-OrgID for multi-tenancy
+	Headers map[string]string
+}
+
+// lokiEvent is the line of an event
+type lokiEvent struct {
+	Event           string  `json:"event"`
+	Table           string  `json:"table"`
+	Key             string  `json:"key"`
+	DataType        string  `json:"data_type"`
+	Value           int     `json:"value"`
+	Threshold       int     `json:"threshold"`
+	Peak            int     `json:"peak"`
+	Since           string  `json:"since"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	Message         string  `json:"message"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// Send pushes the events
+func (s LokiSink) Send(ctx context.Context, events []Event) error {
+	streams := make(map[string]*lokiStream)
+	var keys []string
+	for _, e := range events {
+		key := e.Table + "\x00" + e.Kind
+		stream, ok := streams[key]
+		if !ok {
+			labels := map[string]string{"job": syslogAppName, "table": e.Table, "event": e.Kind}
+			for name, value := range s.Labels {
+				labels[name] = value
+			}
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			keys = append(keys, key)
+		}
+		line, err := json.Marshal(lokiEvent{
+			Event: e.Kind, Table: e.Table, Key: e.Key, DataType: e.DataType,
+			Value: e.Value, Threshold: e.Threshold, Peak: e.Peak,
+			Since:           e.Since.UTC().Format(time.RFC3339Nano),
+			DurationSeconds: e.Duration.Seconds(),
+			Message:         e.Message(),
+		})
+		if err != nil {
+			return err
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), string(line)})
+	}
+	sort.Strings(keys)
+	push := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, key := range keys {
+		push.Streams = append(push.Streams, streams[key])
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(s.URL, "/") + "/loki/api/v1/push"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Failed to create Loki request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to push events to %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Loki %s answered %s: %s", url, resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// BackendEvents sends an event whenever a key crosses the threshold of its table or drops back
+// under it. It compares consecutive runs, so it needs the exporter to run as a daemon.
+const BackendEvents = "events"
+
+// Kinds of events
+const (
+	// EventOverThreshold is sent when the value of a key first goes above the threshold
+	EventOverThreshold = "over_threshold"
+	// EventUnderThreshold is sent when the value of a key drops back under the threshold,
+	// or the key expires from the table
+	EventUnderThreshold = "under_threshold"
+)
+
+// Event is a key of a stick-table crossing the threshold of the table
+type Event struct {
+	// Time is when the table was queried
+	Time time.Time
+	// Kind is EventOverThreshold or EventUnderThreshold
+	Kind     string
+	Table    string
+	Key      string
+	DataType string
+	// Value is the value of the key, zero when it expired from the table
+	Value     int
+	Threshold int
+	// Peak is the highest value of the key while it was over the threshold
+	Peak int
+	// Since is when the key went over the threshold
+	Since time.Time
+	// Duration is how long the key stayed over the threshold, zero for EventOverThreshold
+	Duration time.Duration
+}
+
+// Message returns a human readable description of the event
+func (e Event) Message() string {
+	if e.Kind == EventOverThreshold {
+		return fmt.Sprintf("%s went over the threshold of %s: %s %d > %d", e.Key, e.Table, e.DataType, e.Value, e.Threshold)
+	}
+	return fmt.Sprintf("%s dropped back under the threshold of %s after %s: %s %d, peak %d", e.Key, e.Table, e.Duration, e.DataType, e.Value, e.Peak)
+}
+
+// EventSink delivers the events, e.g. to syslog or Loki
+type EventSink interface {
+	Send(ctx context.Context, events []Event) error
+}
+
+// Sinks of the events
+const (
+	SinkSyslog = "syslog"
+	SinkLoki   = "loki"
+)
+
+// EventsConfig selects and configures the sink of the events
+type EventsConfig struct {
+	// Sink is SinkSyslog or SinkLoki, syslog when empty
+	Sink   string
+	Syslog SyslogSink
+	Loki   LokiSink
+}
+
+func (c EventsConfig) sink() (EventSink, error) {
+	switch c.Sink {
+	case SinkSyslog, "":
+		return c.Syslog, nil
+	case SinkLoki:
+		return c.Loki, nil
+	default:
+		return nil, fmt.Errorf("Unsupported event sink '%s'", c.Sink)
+	}
+}
+
+// incident is a key over the threshold of its table
+type incident struct {
+	since time.Time
+	peak  int
+}
+
+// eventsOutput tracks the keys over threshold between runs and sends an event when they change
+type eventsOutput struct {
+	sink EventSink
+	// incidents holds the keys over threshold, per table
+	incidents map[string]map[netip.Addr]*incident
+}
+
+func newEventsOutput(cfg EventsConfig) (*eventsOutput, error) {
+	sink, err := cfg.sink()
+	if err != nil {
+		return nil, err
+	}
+	return &eventsOutput{sink: sink, incidents: make(map[string]map[netip.Addr]*incident)}, nil
+}
+
+// Write sends the events of the tables since the previous run
+func (o *eventsOutput) Write(ctx context.Context, e *StickTableExporter) error {
+	events := o.update(e)
+	if len(events) == 0 {
+		return nil
+	}
+	if err := o.sink.Send(ctx, events); err != nil {
+		return fmt.Errorf("Failed to send %d events: %v", len(events), err)
+	}
+	e.logger.Debug("Sent events", "events", len(events))
+
+	return nil
+}
+
+// update returns the events of the tables with a threshold, by table and key
+func (o *eventsOutput) update(e *StickTableExporter) []Event {
+	names := make([]string, 0, len(e.tables))
+	for name := range e.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var events []Event
+	for _, name := range names {
+		t := e.tables[name]
+		if !t.hasThreshold {
+			continue
+		}
+		at := e.queriedAt[name]
+		incidents, ok := o.incidents[name]
+		if !ok {
+			incidents = make(map[netip.Addr]*incident)
+			o.incidents[name] = incidents
+		}
+		event := func(kind string, ip netip.Addr, value int, inc *incident) Event {
+			ev := Event{
+				Time: at, Kind: kind, Table: name, Key: e.anonymizer.Key(ip), DataType: t.dataType,
+				Value: value, Threshold: t.threshold, Peak: inc.peak, Since: inc.since,
+			}
+			if kind == EventUnderThreshold {
+				ev.Duration = at.Sub(inc.since)
+			}
+			return ev
+		}
+
+		ips := make([]netip.Addr, 0, len(t.stickData)+len(incidents))
+		for ip := range t.stickData {
+			ips = append(ips, ip)
+		}
+		for ip := range incidents {
+			if _, ok := t.stickData[ip]; !ok {
+				ips = append(ips, ip)
+			}
+		}
+		sort.Slice(ips, func(i, j int) bool { return ips[i].Less(ips[j]) })
+		for _, ip := range ips {
+			// An expired key has a value of zero
+			value := t.stickData[ip]
+			inc, over := incidents[ip]
+			switch {
+			case value > t.threshold && !over:
+				inc = &incident{since: at, peak: value}
+				incidents[ip] = inc
+				events = append(events, event(EventOverThreshold, ip, value, inc))
+			case value > t.threshold:
+				inc.peak = max(inc.peak, value)
+			case over:
+				delete(incidents, ip)
+				events = append(events, event(EventUnderThreshold, ip, value, inc))
+			}
+		}
+	}
+	return events
+}
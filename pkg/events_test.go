@@ -0,0 +1,190 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// recordingSink keeps the events it receives
+type recordingSink struct {
+	events []Event
+}
+
+func (s *recordingSink) Send(ctx context.Context, events []Event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func Test_eventsOutput(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	a, b := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+	// The value of the keys at every run, the threshold is 100
+	runs := []map[netip.Addr]int{
+		{a: 50, b: 150},
+		{a: 120, b: 300},
+		{a: 130, b: 80},
+		{a: 90},
+		{},
+	}
+	expected := [][]Event{
+		{
+			{Kind: EventOverThreshold, Key: "10.0.0.2", Value: 150, Peak: 150, Since: start},
+		},
+		{
+			{Kind: EventOverThreshold, Key: "10.0.0.1", Value: 120, Peak: 120, Since: start.Add(time.Minute)},
+		},
+		{
+			{Kind: EventUnderThreshold, Key: "10.0.0.2", Value: 80, Peak: 300, Since: start, Duration: 2 * time.Minute},
+		},
+		{
+			{Kind: EventUnderThreshold, Key: "10.0.0.1", Value: 90, Peak: 130, Since: start.Add(time.Minute), Duration: 2 * time.Minute},
+		},
+		nil,
+	}
+
+	sink := &recordingSink{}
+	o := &eventsOutput{sink: sink, incidents: make(map[string]map[netip.Addr]*incident)}
+	for i, data := range runs {
+		at := start.Add(time.Duration(i) * time.Minute)
+		e := NewStickTableExporter(10, testLogger)
+		e.SetThreshold("t1", 100)
+		e.SetQueried("t1", at, time.Millisecond)
+		e.UpdateData("t1", "http_req_rate", data)
+		// A table without threshold never has events
+		e.UpdateData("t2", "http_req_rate", data)
+
+		sink.events = nil
+		if err := o.Write(context.Background(), e); err != nil {
+			t.Fatalf("Write() errored: %v", err)
+		}
+		for j := range expected[i] {
+			expected[i][j].Time = at
+			expected[i][j].Table = "t1"
+			expected[i][j].DataType = "http_req_rate"
+			expected[i][j].Threshold = 100
+		}
+		if diff := cmp.Diff(expected[i], sink.events); diff != "" {
+			t.Errorf("events of run %d mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+}
+
+// testEvent is an event as sent when a key drops back under the threshold
+var testEvent = Event{
+	Time:      time.Date(2024, 5, 2, 10, 2, 0, 0, time.UTC),
+	Kind:      EventUnderThreshold,
+	Table:     "t1",
+	Key:       "10.0.0.2",
+	DataType:  "http_req_rate",
+	Value:     80,
+	Threshold: 100,
+	Peak:      300,
+	Since:     time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC),
+	Duration:  2 * time.Minute,
+}
+
+func Test_SyslogSink(t *testing.T) {
+	t.Parallel()
+	socket := filepath.Join(t.TempDir(), "log.sock")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	tests := []struct {
+		name     string
+		sdID     string
+		expected string
+	}{
+		{
+			name:     "without structured data",
+			expected: `- 10.0.0.2 dropped back under the threshold of t1 after 2m0s: http_req_rate 80, peak 300`,
+		},
+		{
+			name: "with structured data",
+			sdID: "stickTable@64512",
+			expected: `[stickTable@64512 table="t1" key="10.0.0.2" data_type="http_req_rate" value="80" threshold="100" peak="300" since="2024-05-02T10:00:00Z" duration_seconds="120"] ` +
+				`10.0.0.2 dropped back under the threshold of t1 after 2m0s: http_req_rate 80, peak 300`,
+		},
+	}
+	for _, tt := range tests {
+		sink := SyslogSink{Address: socket, Hostname: "lb1", SDID: tt.sdID}
+		if err := sink.Validate(); err != nil {
+			t.Fatalf("%s: Validate() errored: %v", tt.name, err)
+		}
+		if err := sink.Send(context.Background(), []Event{testEvent}); err != nil {
+			t.Fatalf("%s: Send() errored: %v", tt.name, err)
+		}
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 4096)
+		n, err := listener.Read(buf)
+		if err != nil {
+			t.Fatalf("%s: Failed to read message: %v", tt.name, err)
+		}
+
+		expected := regexp.MustCompile(`^<133>1 2024-05-02T10:02:00Z lb1 haproxy-table-exporter \d+ under_threshold ` + regexp.QuoteMeta(tt.expected) + `$`)
+		if !expected.Match(buf[:n]) {
+			t.Errorf("%s: message = %s, want %s", tt.name, buf[:n], expected)
+		}
+	}
+
+	for _, sdID := range []string{"stickTable", "stick table@64512", "stick=table@64512", "stickTable@x", "stickTableWithAVeryLongName@64512"} {
+		if err := (SyslogSink{SDID: sdID}).Validate(); err == nil {
+			t.Errorf("Validate() accepted the SD-ID %s", sdID)
+		}
+	}
+}
+
+func Test_LokiSink(t *testing.T) {
+	t.Parallel()
+	server, requests := startTestReceiver(t, http.StatusNoContent)
+	sink := LokiSink{URL: server.URL, Labels: map[string]string{"host": "lb1"}, Headers: map[string]string{"X-Scope-OrgID": "tenant1"}}
+	if err := sink.Send(context.Background(), []Event{testEvent}); err != nil {
+		t.Fatalf("Send() errored: %v", err)
+	}
+
+	got := requests()
+	if len(got) != 1 || got[0].path != "/loki/api/v1/push" {
+		t.Fatalf("requests = %v, want a single push", got)
+	}
+	if tenant := got[0].header.Get("X-Scope-OrgID"); tenant != "tenant1" {
+		t.Errorf("X-Scope-OrgID = %s, want tenant1", tenant)
+	}
+	var push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(got[0].body, &push); err != nil {
+		t.Fatalf("Invalid push: %v", err)
+	}
+	if len(push.Streams) != 1 || len(push.Streams[0].Values) != 1 {
+		t.Fatalf("push = %+v, want a single line", push)
+	}
+	expectedStream := map[string]string{"job": "haproxy-table-exporter", "table": "t1", "event": "under_threshold", "host": "lb1"}
+	if diff := cmp.Diff(expectedStream, push.Streams[0].Stream); diff != "" {
+		t.Errorf("stream mismatch (-want +got):\n%s", diff)
+	}
+	if ts := push.Streams[0].Values[0][0]; ts != "1714644120000000000" {
+		t.Errorf("timestamp = %s", ts)
+	}
+	var line map[string]any
+	if err := json.Unmarshal([]byte(push.Streams[0].Values[0][1]), &line); err != nil {
+		t.Fatalf("Invalid line: %v", err)
+	}
+	if line["key"] != "10.0.0.2" || line["peak"] != 300.0 || line["duration_seconds"] != 120.0 {
+		t.Errorf("line = %v", line)
+	}
+}
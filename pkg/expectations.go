@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// What to do when a stick-table at runtime differs from its expectation
+const (
+	MismatchIgnore = "ignore"
+	MismatchWarn   = "warn"
+	MismatchFail   = "fail"
+)
+
+// TableExpectation is how a stick-table is expected to be declared, zero values aren't checked
+type TableExpectation struct {
+	Type   string
+	Size   int
+	Period time.Duration
+}
+
+// ExpectationsFromHAProxyConfig returns the expectations of every stick-table declared in
+// the HAProxy configuration, the period is the one of the data type exported for the table.
+func ExpectationsFromHAProxyConfig(c *HAProxyConfig, tables []Table) map[string]TableExpectation {
+	expectations := make(map[string]TableExpectation)
+	for _, d := range c.Tables {
+		e := TableExpectation{Type: d.Type, Size: d.Size}
+		for _, t := range tables {
+			if t.Name != d.Name {
+				continue
+			}
+			for _, s := range d.Store {
+				if s.Name == t.DataType {
+					e.Period = s.Period
+				}
+			}
+		}
+		expectations[d.Name] = e
+	}
+
+	return expectations
+}
+
+// checkExpectation compares the header and period of a table at runtime against its expectation
+// and returns a description of every difference.
+func checkExpectation(e TableExpectation, h TableHeader, period int, hasPeriod bool) []string {
+	var diffs []string
+	if e.Type != "" && e.Type != h.Type {
+		diffs = append(diffs, fmt.Sprintf("type is '%s', expected '%s'", h.Type, e.Type))
+	}
+	if e.Size != 0 && e.Size != h.Size {
+		diffs = append(diffs, fmt.Sprintf("size is %d, expected %d", h.Size, e.Size))
+	}
+	if e.Period != 0 && hasPeriod && int64(period) != e.Period.Milliseconds() {
+		diffs = append(diffs, fmt.Sprintf("period is %dms, expected %dms", period, e.Period.Milliseconds()))
+	}
+
+	return diffs
+}
+
+// mismatchError builds the error reported for the differences of a table
+func mismatchError(table string, diffs []string) error {
+	return fmt.Errorf("Stick-table %s differs from its expectation: %s", table, strings.Join(diffs, ", "))
+}
@@ -0,0 +1,51 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_checkExpectation(t *testing.T) {
+	t.Parallel()
+	header := TableHeader{Name: "table_requests_limiter_src_ip", Type: "ip", Size: 1048576}
+	tests := []struct {
+		name        string
+		expectation TableExpectation
+		period      int
+		hasPeriod   bool
+		expected    []string
+	}{
+		{
+			name:        "matching declaration",
+			expectation: TableExpectation{Type: "ip", Size: 1 << 20, Period: 60 * time.Second},
+			period:      60000,
+			hasPeriod:   true,
+		},
+		{
+			name:        "changed period",
+			expectation: TableExpectation{Type: "ip", Size: 1 << 20, Period: 60 * time.Second},
+			period:      10000,
+			hasPeriod:   true,
+			expected:    []string{"period is 10000ms, expected 60000ms"},
+		},
+		{
+			name:        "unknown period",
+			expectation: TableExpectation{Period: 60 * time.Second},
+		},
+		{
+			name:        "changed type and size",
+			expectation: TableExpectation{Type: "ipv6", Size: 1024},
+			expected:    []string{"type is 'ip', expected 'ipv6'", "size is 1048576, expected 1024"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := checkExpectation(tt.expectation, header, tt.period, tt.hasPeriod)
+			if diff := cmp.Diff(tt.expected, diffs); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
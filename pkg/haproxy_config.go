@@ -0,0 +1,508 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StoreType is a data type stored in a stick-table, e.g. http_req_rate(10s)
+type StoreType struct {
+	// Name of the data type, e.g. http_req_rate
+	Name string
+	// Period over which a rate is computed, zero for data types which aren't rates
+	Period time.Duration
+}
+
+// StickTableDeclaration is a stick-table as declared in haproxy.cfg
+type StickTableDeclaration struct {
+	// Name of the table, the proxy name or <peers section>/<table> for tables declared in a peers section
+	Name string
+	// Type of the key, e.g. ip, ipv6, integer, string or binary
+	Type string
+	// Size is the maximum number of entries
+	Size int
+	// Expire is the time after which an entry is purged
+	Expire time.Duration
+	// Store lists the data types stored with every entry
+	Store []StoreType
+}
+
+// Stores reports whether the table stores the data type
+func (d StickTableDeclaration) Stores(dataType string) bool {
+	for _, s := range d.Store {
+		if s.Name == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+// ThresholdRule is a comparison against a tracked counter found in a deny rule,
+// e.g. `http-request deny if { sc_http_req_rate(0) gt 100 }`
+type ThresholdRule struct {
+	// Table the counter is tracked in
+	Table string
+	// DataType compared, e.g. http_req_rate
+	DataType string
+	// Value above which a client is denied
+	Value int
+}
+
+// HAProxyConfig holds the stick-tables and thresholds found in a HAProxy configuration
+type HAProxyConfig struct {
+	Tables     []StickTableDeclaration
+	Thresholds []ThresholdRule
+}
+
+// Sections of the HAProxy configuration, a line starting with one of them opens a new section
+var configSections = map[string]bool{
+	"global": true, "defaults": true, "frontend": true, "backend": true, "listen": true,
+	"peers": true, "resolvers": true, "userlist": true, "mailers": true, "program": true,
+	"http-errors": true, "ring": true, "cache": true, "log-forward": true, "fcgi-app": true,
+	"crt-store": true, "traces": true,
+}
+
+// Counters used in ACLs, either sc_<type>(<sc>[,<table>]), sc<sc>_<type>[(<table>)] or src_<type>[(<table>)]
+var (
+	scFetch    = regexp.MustCompile(`^sc_([[:alnum:]_]+)\(([[:digit:]]+)(?:,([^)]+))?\)$`)
+	scNumFetch = regexp.MustCompile(`^sc([[:digit:]]+)_([[:alnum:]_]+)(?:\(([^)]+)\))?$`)
+	srcFetch   = regexp.MustCompile(`^src_([[:alnum:]_]+)(?:\(([^)]+)\))?$`)
+	trackSC    = regexp.MustCompile(`^track-sc([[:digit:]]+)$`)
+)
+
+// proxySection accumulates the rules of a frontend, backend or listen section which
+// can only be resolved once the whole section is read.
+type proxySection struct {
+	name string
+	// hasTable is true when the section declares its own stick-table
+	hasTable bool
+	// trackers maps a sticky counter number to the table it tracks
+	trackers map[int]string
+	acls     map[string][][]string
+	// conditions of deny rules, one slice of tokens per rule
+	conditions [][]string
+}
+
+// ReadHAProxyConfig parses the HAProxy configuration file at path
+func ReadHAProxyConfig(path string) (*HAProxyConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseHAProxyConfig(f)
+}
+
+// ParseHAProxyConfig extracts the stick-table declarations and the simple sc_* comparisons
+// of deny rules from a HAProxy configuration. Anything it doesn't understand is ignored.
+func ParseHAProxyConfig(r io.Reader) (*HAProxyConfig, error) {
+	cfg := &HAProxyConfig{}
+	var section string
+	var proxy *proxySection
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := configFields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if configSections[fields[0]] {
+			if proxy != nil {
+				cfg.Thresholds = append(cfg.Thresholds, proxy.thresholds()...)
+				proxy = nil
+			}
+			section = ""
+			if len(fields) > 1 {
+				section = fields[1]
+			}
+			switch fields[0] {
+			case "frontend", "backend", "listen":
+				proxy = &proxySection{
+					name:     section,
+					trackers: make(map[int]string),
+					acls:     make(map[string][][]string),
+				}
+			case "peers":
+			default:
+				section = ""
+			}
+			continue
+		}
+
+		switch {
+		case proxy != nil:
+			if err := proxy.parseLine(cfg, fields); err != nil {
+				return nil, fmt.Errorf("Line %d: %v", lineNumber, err)
+			}
+		case section != "" && fields[0] == "table" && len(fields) > 1:
+			// Tables declared in a peers section are named <peers section>/<table> at runtime
+			d, err := parseStickTable(section+"/"+fields[1], fields[2:])
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %v", lineNumber, err)
+			}
+			cfg.Tables = append(cfg.Tables, d)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		cfg.Thresholds = append(cfg.Thresholds, proxy.thresholds()...)
+	}
+
+	return cfg, nil
+}
+
+// configFields splits a configuration line on whitespace and drops comments
+func configFields(line string) []string {
+	fields := strings.Fields(line)
+	for i, f := range fields {
+		if strings.HasPrefix(f, "#") {
+			return fields[:i]
+		}
+	}
+	return fields
+}
+
+// parseLine handles a single line of a proxy section
+func (p *proxySection) parseLine(cfg *HAProxyConfig, fields []string) error {
+	switch fields[0] {
+	case "stick-table":
+		d, err := parseStickTable(p.name, fields[1:])
+		if err != nil {
+			return err
+		}
+		p.hasTable = true
+		cfg.Tables = append(cfg.Tables, d)
+	case "acl":
+		if len(fields) > 2 {
+			p.acls[fields[1]] = append(p.acls[fields[1]], fields[2:])
+		}
+	case "http-request", "tcp-request", "http-response":
+		action := fields[1:]
+		// tcp-request rules have a ruleset, e.g. tcp-request connection track-sc0 src
+		if fields[0] == "tcp-request" && len(action) > 0 {
+			action = action[1:]
+		}
+		if len(action) == 0 {
+			return nil
+		}
+		if m := trackSC.FindStringSubmatch(action[0]); m != nil {
+			sc, _ := strconv.Atoi(m[1])
+			table := p.name
+			for i := 1; i+1 < len(action); i++ {
+				if action[i] == "table" {
+					table = action[i+1]
+					break
+				}
+			}
+			p.trackers[sc] = table
+			return nil
+		}
+		switch action[0] {
+		case "deny", "reject", "tarpit":
+			for i, f := range action {
+				if f == "if" {
+					p.conditions = append(p.conditions, action[i+1:])
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// thresholds resolves the deny rules of the section into threshold rules
+func (p *proxySection) thresholds() []ThresholdRule {
+	var rules []ThresholdRule
+	for _, condition := range p.conditions {
+		negate := false
+		for i := 0; i < len(condition); i++ {
+			switch token := condition[i]; token {
+			case "!":
+				negate = true
+				continue
+			case "||", "or":
+			case "{":
+				end := i + 1
+				for end < len(condition) && condition[end] != "}" {
+					end++
+				}
+				if !negate {
+					if rule, ok := p.threshold(condition[i+1 : end]); ok {
+						rules = append(rules, rule)
+					}
+				}
+				i = end
+			default:
+				if !negate {
+					for _, expr := range p.acls[token] {
+						if rule, ok := p.threshold(expr); ok {
+							rules = append(rules, rule)
+						}
+					}
+				}
+			}
+			negate = false
+		}
+	}
+
+	return rules
+}
+
+// threshold turns an ACL expression such as `sc_http_req_rate(0) gt 100` into a threshold rule.
+// Only the gt and ge operators are supported as they are the ones used to deny clients.
+func (p *proxySection) threshold(expr []string) (ThresholdRule, bool) {
+	if len(expr) < 3 {
+		return ThresholdRule{}, false
+	}
+
+	var dataType, table string
+	sc := -1
+	if m := scFetch.FindStringSubmatch(expr[0]); m != nil {
+		dataType, table = m[1], m[3]
+		sc, _ = strconv.Atoi(m[2])
+	} else if m := scNumFetch.FindStringSubmatch(expr[0]); m != nil {
+		dataType, table = m[2], m[3]
+		sc, _ = strconv.Atoi(m[1])
+	} else if m := srcFetch.FindStringSubmatch(expr[0]); m != nil {
+		dataType, table = m[1], m[2]
+		if table == "" && p.hasTable {
+			table = p.name
+		}
+	} else {
+		return ThresholdRule{}, false
+	}
+	// sc_get_gpc0 and friends read the gpc0 data type
+	dataType = strings.TrimPrefix(dataType, "get_")
+	if table == "" && sc >= 0 {
+		table = p.trackers[sc]
+	}
+	if table == "" {
+		return ThresholdRule{}, false
+	}
+
+	// Skip matching flags, e.g. -m int
+	args := expr[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if args[0] == "-m" && len(args) > 1 {
+			args = args[1:]
+		}
+		args = args[1:]
+	}
+	if len(args) != 2 {
+		return ThresholdRule{}, false
+	}
+	value, err := strconv.Atoi(args[1])
+	if err != nil {
+		return ThresholdRule{}, false
+	}
+	switch args[0] {
+	case "gt":
+	case "ge":
+		value--
+	default:
+		return ThresholdRule{}, false
+	}
+
+	return ThresholdRule{Table: table, DataType: dataType, Value: value}, true
+}
+
+// parseStickTable parses the arguments of a stick-table declaration, e.g.
+// type ip size 1m expire 60s store http_req_rate(60s),conn_cnt
+func parseStickTable(name string, args []string) (StickTableDeclaration, error) {
+	d := StickTableDeclaration{Name: name}
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			break
+		}
+		switch args[i] {
+		case "type":
+			i++
+			d.Type = args[i]
+		case "size":
+			i++
+			size, err := ParseSize(args[i])
+			if err != nil {
+				return d, fmt.Errorf("Invalid size '%s' for stick-table %s", args[i], name)
+			}
+			d.Size = size
+		case "expire":
+			i++
+			expire, err := ParseTime(args[i])
+			if err != nil {
+				return d, fmt.Errorf("Invalid expire '%s' for stick-table %s", args[i], name)
+			}
+			d.Expire = expire
+		case "store":
+			i++
+			for _, s := range splitStoreList(args[i]) {
+				st, err := parseStoreType(s)
+				if err != nil {
+					return d, fmt.Errorf("Invalid store '%s' for stick-table %s: %v", s, name, err)
+				}
+				d.Store = append(d.Store, st)
+			}
+		case "len", "peers", "srvkey", "write-to":
+			i++
+		}
+	}
+	if d.Type == "" {
+		return d, fmt.Errorf("Missing type for stick-table %s", name)
+	}
+
+	return d, nil
+}
+
+// splitStoreList splits a store list on the commas which aren't inside parentheses,
+// e.g. gpc_rate(2,10s),conn_cnt
+func splitStoreList(list string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(items, list[start:])
+}
+
+// parseStoreType parses a single data type of a store list, e.g. http_req_rate(10s)
+func parseStoreType(s string) (StoreType, error) {
+	name, args, found := strings.Cut(s, "(")
+	st := StoreType{Name: name}
+	if !found {
+		return st, nil
+	}
+	args, ok := strings.CutSuffix(args, ")")
+	if !ok {
+		return st, fmt.Errorf("missing closing parenthesis")
+	}
+	// The period is the last argument of rate data types, e.g. gpc_rate(2,10s)
+	if strings.HasSuffix(name, "_rate") {
+		parts := strings.Split(args, ",")
+		period, err := ParseTime(parts[len(parts)-1])
+		if err != nil {
+			return st, err
+		}
+		st.Period = period
+	}
+
+	return st, nil
+}
+
+// ParseSize parses a HAProxy size with an optional k, m or g suffix
+func ParseSize(s string) (int, error) {
+	if s == "" {
+		return 0, fmt.Errorf("invalid size, it cannot be empty")
+	}
+	multiplier := 1
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		multiplier = 1 << 10
+	case "m":
+		multiplier = 1 << 20
+	case "g":
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s", s)
+	}
+
+	return n * multiplier, nil
+}
+
+// ParseTime parses a HAProxy time, which is in milliseconds unless a unit is given
+func ParseTime(s string) (time.Duration, error) {
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{
+		{"us", time.Microsecond},
+		{"ms", time.Millisecond},
+		{"s", time.Second},
+		{"m", time.Minute},
+		{"h", time.Hour},
+		{"d", 24 * time.Hour},
+	}
+	unit := time.Millisecond
+	for _, u := range units {
+		if v, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, unit = v, u.unit
+			break
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid time %s", s)
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+// AutoConfigure returns the tables to query and their thresholds. Every ip stick-table
+// is queried for the data type its first threshold rule compares, falling back to the
+// first rate it stores. Tables storing no data type can't be exported and are skipped.
+func (c *HAProxyConfig) AutoConfigure() ([]Table, map[string]int) {
+	var tables []Table
+	thresholds := make(map[string]int)
+	for _, d := range c.Tables {
+		if d.Type != "ip" || len(d.Store) == 0 {
+			continue
+		}
+
+		table := Table{Name: d.Name}
+		for _, rule := range c.Thresholds {
+			if rule.Table == d.Name && d.Stores(rule.DataType) {
+				table.DataType = rule.DataType
+				break
+			}
+		}
+		if table.DataType == "" {
+			table.DataType = d.Store[0].Name
+			for _, s := range d.Store {
+				if strings.HasSuffix(s.Name, "_rate") {
+					table.DataType = s.Name
+					break
+				}
+			}
+		}
+
+		// The lowest threshold is the one clients hit first
+		for _, rule := range c.Thresholds {
+			if rule.Table != d.Name || rule.DataType != table.DataType {
+				continue
+			}
+			if v, ok := thresholds[d.Name]; !ok || rule.Value < v {
+				thresholds[d.Name] = rule.Value
+			}
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, thresholds
+}
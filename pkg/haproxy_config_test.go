@@ -0,0 +1,169 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testHAProxyConfig = `
+global
+    stats socket /var/lib/haproxy/stats level admin
+
+frontend fe_main
+    bind :80
+    acl too_many_conns sc1_conn_cur ge 20
+    http-request track-sc0 src table table_requests_limiter_src_ip
+    tcp-request connection track-sc1 src table table_conn_limiter_src_ip
+    http-request deny deny_status 429 if { sc_http_req_rate(0) gt 100 } # rate limit
+    http-request deny if too_many_conns
+    http-request deny if !{ sc_http_err_rate(0) gt 5 }
+    default_backend be_app
+
+backend table_requests_limiter_src_ip
+    stick-table type ip size 1m expire 60s store http_req_rate(60s),conn_cnt
+
+backend table_conn_limiter_src_ip
+    stick-table type ip size 100k expire 10m store conn_cur,conn_rate(3s)
+
+backend be_app
+    stick-table type string len 32 size 1k store gpc0
+    http-request deny if { src_get_gpc0 gt 0 }
+
+peers mypeers
+    peer lb1 10.0.0.1:1024
+    table sessions type ipv6 size 2k expire 30s store http_req_cnt
+`
+
+func Test_ParseHAProxyConfig(t *testing.T) {
+	t.Parallel()
+	cfg, err := ParseHAProxyConfig(strings.NewReader(testHAProxyConfig))
+	if err != nil {
+		t.Fatalf("ParseHAProxyConfig() errored: %v", err)
+	}
+
+	expected := &HAProxyConfig{
+		Tables: []StickTableDeclaration{
+			{
+				Name:   "table_requests_limiter_src_ip",
+				Type:   "ip",
+				Size:   1 << 20,
+				Expire: 60 * time.Second,
+				Store:  []StoreType{{Name: "http_req_rate", Period: 60 * time.Second}, {Name: "conn_cnt"}},
+			},
+			{
+				Name:   "table_conn_limiter_src_ip",
+				Type:   "ip",
+				Size:   100 << 10,
+				Expire: 10 * time.Minute,
+				Store:  []StoreType{{Name: "conn_cur"}, {Name: "conn_rate", Period: 3 * time.Second}},
+			},
+			{
+				Name:  "be_app",
+				Type:  "string",
+				Size:  1 << 10,
+				Store: []StoreType{{Name: "gpc0"}},
+			},
+			{
+				Name:   "mypeers/sessions",
+				Type:   "ipv6",
+				Size:   2 << 10,
+				Expire: 30 * time.Second,
+				Store:  []StoreType{{Name: "http_req_cnt"}},
+			},
+		},
+		Thresholds: []ThresholdRule{
+			{Table: "table_requests_limiter_src_ip", DataType: "http_req_rate", Value: 100},
+			{Table: "table_conn_limiter_src_ip", DataType: "conn_cur", Value: 19},
+			{Table: "be_app", DataType: "gpc0", Value: 0},
+		},
+	}
+	if diff := cmp.Diff(expected, cfg); diff != "" {
+		t.Error(diff)
+	}
+
+	tables, thresholds := cfg.AutoConfigure()
+	expectedTables := []Table{
+		{Name: "table_requests_limiter_src_ip", DataType: "http_req_rate"},
+		{Name: "table_conn_limiter_src_ip", DataType: "conn_cur"},
+	}
+	if diff := cmp.Diff(expectedTables, tables); diff != "" {
+		t.Error(diff)
+	}
+	expectedThresholds := map[string]int{
+		"table_requests_limiter_src_ip": 100,
+		"table_conn_limiter_src_ip":     19,
+	}
+	if diff := cmp.Diff(expectedThresholds, thresholds); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func Test_ParseHAProxyConfig_errors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		input       string
+		expectedErr string
+	}{
+		{
+			name:        "invalid size",
+			input:       "backend t\n  stick-table type ip size 1x store conn_cnt\n",
+			expectedErr: "Line 2: Invalid size",
+		},
+		{
+			name:        "invalid expire",
+			input:       "backend t\n  stick-table type ip expire soon store conn_cnt\n",
+			expectedErr: "Line 2: Invalid expire",
+		},
+		{
+			name:        "invalid period",
+			input:       "backend t\n  stick-table type ip store http_req_rate(fast)\n",
+			expectedErr: "Line 2: Invalid store",
+		},
+		{
+			name:        "missing type",
+			input:       "backend t\n  stick-table size 1m store conn_cnt\n",
+			expectedErr: "Line 2: Missing type",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseHAProxyConfig(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatalf("expected error message = %v, got nil", tt.expectedErr)
+			}
+			if !strings.HasPrefix(err.Error(), tt.expectedErr) {
+				t.Errorf("error message  --%v--, want something which starts with --%v--", err.Error(), tt.expectedErr)
+			}
+		})
+	}
+}
+
+func Test_ParseSize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		size     string
+		expected int
+		wantErr  bool
+	}{
+		{size: "100", expected: 100},
+		{size: "1k", expected: 1 << 10},
+		{size: "1M", expected: 1 << 20},
+		{size: "2g", expected: 2 << 30},
+		{size: "", wantErr: true},
+		{size: "m", wantErr: true},
+		{size: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.size)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+		}
+		if got != tt.expected {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.size, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package exporter
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BackendHistory records the values of every run in a local database, queried by the history command
+const BackendHistory = "history"
+
+// DefaultHistoryRetention is how long the snapshots are kept when HistoryConfig.Retention is zero
+const DefaultHistoryRetention = 24 * time.Hour
+
+// HistoryConfig records a snapshot of the values of every table at every run
+type HistoryConfig struct {
+	// Path is the file of the database
+	Path string
+	// Retention is how long the snapshots are kept, DefaultHistoryRetention when zero
+	Retention time.Duration
+}
+
+// Write records the snapshots of the tables and removes the expired ones. The database is only
+// open while writing, so the history command can read it between two runs.
+func (c HistoryConfig) Write(ctx context.Context, e *StickTableExporter) error {
+	h, err := OpenHistory(c.Path, false)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	for name, t := range e.tables {
+		// Addresses anonymized to the same key are summed
+		values := make(map[string]int, len(t.stickData))
+		for ip, value := range t.stickData {
+			values[e.anonymizer.Key(ip)] += value
+		}
+		if err := h.Record(name, e.queriedAt[name], t.dataType, values); err != nil {
+			return err
+		}
+	}
+	retention := c.Retention
+	if retention <= 0 {
+		retention = DefaultHistoryRetention
+	}
+	pruned, err := h.Prune(time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	e.logger.Debug("Recorded history", "file", c.Path, "tables", len(e.tables), "pruned", pruned)
+
+	return nil
+}
+
+// History is a database of snapshots of the stick-tables. Every table has a bucket holding a
+// bucket per snapshot, named after the time of the snapshot in nanoseconds, in which the values
+// are indexed by key.
+type History struct {
+	db *bolt.DB
+}
+
+// dataTypeKey holds the data type of the values in the bucket of a table
+var dataTypeKey = []byte("data_type")
+
+// OpenHistory opens the database, creating it unless readOnly is true. It waits for up to a
+// second for the exporter to release the database.
+func OpenHistory(path string, readOnly bool) (*History, error) {
+	if readOnly {
+		// bbolt can't create the database in read-only mode and fails with an obscure error
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("Failed to open history: %v", err)
+		}
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open history %s: %v", path, err)
+	}
+	return &History{db: db}, nil
+}
+
+// Close closes the database
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// snapshotName returns the name of the bucket of a snapshot, sorting in time order
+func snapshotName(at time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(at.UnixNano()))
+}
+
+// snapshotTime returns the time of a snapshot from the name of its bucket
+func snapshotTime(name []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(name)))
+}
+
+// Record adds the snapshot of the values of the keys of a table at a time
+func (h *History) Record(table string, at time.Time, dataType string, values map[string]int) error {
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		tb, err := tx.CreateBucketIfNotExists([]byte(table))
+		if err != nil {
+			return err
+		}
+		if err := tb.Put(dataTypeKey, []byte(dataType)); err != nil {
+			return err
+		}
+		snapshot, err := tb.CreateBucketIfNotExists(snapshotName(at))
+		if err != nil {
+			return err
+		}
+		for key, value := range values {
+			if err := snapshot.Put([]byte(key), binary.AppendVarint(nil, int64(value))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to record the history of %s: %v", table, err)
+	}
+	return nil
+}
+
+// Prune removes the snapshots older than before and returns how many were removed
+func (h *History) Prune(before time.Time) (int, error) {
+	pruned := 0
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(table []byte, tb *bolt.Bucket) error {
+			var expired [][]byte
+			c := tb.Cursor()
+			for name, v := c.First(); name != nil; name, v = c.Next() {
+				// The data type is the only value which isn't a snapshot
+				if v != nil {
+					continue
+				}
+				if !snapshotTime(name).Before(before) {
+					break
+				}
+				expired = append(expired, name)
+			}
+			for _, name := range expired {
+				if err := tb.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+			pruned += len(expired)
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to prune the history: %v", err)
+	}
+	return pruned, nil
+}
+
+// forEachSnapshot calls fn for every snapshot of the table between from and to, in time order
+func (h *History) forEachSnapshot(table string, from, to time.Time, fn func(at time.Time, snapshot *bolt.Bucket) error) (string, error) {
+	dataType := ""
+	err := h.db.View(func(tx *bolt.Tx) error {
+		tb := tx.Bucket([]byte(table))
+		if tb == nil {
+			return fmt.Errorf("No history of stick-table %s", table)
+		}
+		dataType = string(tb.Get(dataTypeKey))
+		c := tb.Cursor()
+		for name, v := c.Seek(snapshotName(from)); name != nil; name, v = c.Next() {
+			if v != nil {
+				continue
+			}
+			at := snapshotTime(name)
+			if at.After(to) {
+				break
+			}
+			if err := fn(at, tb.Bucket(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return dataType, err
+}
+
+// HistoryPoint is the value of a key in a snapshot
+type HistoryPoint struct {
+	Time time.Time
+	// Value is the value of the key, zero when it wasn't in the table
+	Value int
+	// Present is false when the key wasn't in the table
+	Present bool
+}
+
+// KeySeries returns the value of a key in every snapshot of the table between from and to, with
+// the data type of the values
+func (h *History) KeySeries(table string, key string, from, to time.Time) (string, []HistoryPoint, error) {
+	var points []HistoryPoint
+	dataType, err := h.forEachSnapshot(table, from, to, func(at time.Time, snapshot *bolt.Bucket) error {
+		p := HistoryPoint{Time: at}
+		if v := snapshot.Get([]byte(key)); v != nil {
+			value, _ := binary.Varint(v)
+			p.Value, p.Present = int(value), true
+		}
+		points = append(points, p)
+		return nil
+	})
+	return dataType, points, err
+}
+
+// HistoryTopKey is a key ranked by its peak value over a window
+type HistoryTopKey struct {
+	Key  string
+	Peak int
+	// PeakTime is the time of the first snapshot with the peak value
+	PeakTime time.Time
+	// Average is the average value of the key in the snapshots it appears in
+	Average float64
+	// Samples is the number of snapshots the key appears in
+	Samples int
+}
+
+// TopKeys returns the limit keys with the highest peak value between from and to, with the data
+// type of the values. Ties are broken by key, limit zero returns all the keys.
+func (h *History) TopKeys(table string, from, to time.Time, limit int) (string, []HistoryTopKey, error) {
+	keys := make(map[string]*HistoryTopKey)
+	sums := make(map[string]int)
+	dataType, err := h.forEachSnapshot(table, from, to, func(at time.Time, snapshot *bolt.Bucket) error {
+		return snapshot.ForEach(func(k, v []byte) error {
+			value64, _ := binary.Varint(v)
+			value := int(value64)
+			top, ok := keys[string(k)]
+			if !ok {
+				top = &HistoryTopKey{Key: string(k), Peak: value, PeakTime: at}
+				keys[string(k)] = top
+			} else if value > top.Peak {
+				top.Peak, top.PeakTime = value, at
+			}
+			top.Samples++
+			sums[string(k)] += value
+			return nil
+		})
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	rows := make([]HistoryTopKey, 0, len(keys))
+	for key, top := range keys {
+		top.Average = float64(sums[key]) / float64(top.Samples)
+		rows = append(rows, *top)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Peak != rows[j].Peak {
+			return rows[i].Peak > rows[j].Peak
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return dataType, rows, nil
+}
+
+// WriteKeySeries writes the values of a key over time as an aligned table, "-" when the key
+// wasn't in the table
+func WriteKeySeries(w io.Writer, dataType string, points []HistoryPoint) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "TIME\t%s\n", strings.ToUpper(dataType))
+	for _, p := range points {
+		value := "-"
+		if p.Present {
+			value = strconv.Itoa(p.Value)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", p.Time.Format(time.RFC3339), value)
+	}
+	return tw.Flush()
+}
+
+// WriteTopKeys writes the top keys as an aligned table
+func WriteTopKeys(w io.Writer, dataType string, rows []HistoryTopKey) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "KEY\tPEAK %s\tPEAK TIME\tAVERAGE\tSAMPLES\n", strings.ToUpper(dataType))
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%.1f\t%d\n", r.Key, r.Peak, r.PeakTime.Format(time.RFC3339), r.Average, r.Samples)
+	}
+	return tw.Flush()
+}
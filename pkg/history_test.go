@@ -0,0 +1,121 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_History(t *testing.T) {
+	t.Parallel()
+	h, err := OpenHistory(filepath.Join(t.TempDir(), "history.db"), false)
+	if err != nil {
+		t.Fatalf("OpenHistory() errored: %v", err)
+	}
+	defer h.Close()
+
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	a, b, c := "10.0.0.1", "10.0.0.2", "2001:db8::1"
+	for i, values := range []map[string]int{
+		{a: 500, b: 10},
+		{a: 5, b: 20},
+		{a: 50, b: 30, c: 40},
+		{b: 40, c: 40},
+	} {
+		if err := h.Record("t1", start.Add(time.Duration(i)*time.Minute), "http_req_rate", values); err != nil {
+			t.Fatalf("Record() errored: %v", err)
+		}
+	}
+	pruned, err := h.Prune(start.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Prune() errored: %v", err)
+	}
+	if pruned != 1 {
+		t.Errorf("Prune() removed %d snapshots, want 1", pruned)
+	}
+
+	dataType, points, err := h.KeySeries("t1", "10.0.0.1", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("KeySeries() errored: %v", err)
+	}
+	expectedPoints := []HistoryPoint{
+		{Time: start.Add(time.Minute), Value: 5, Present: true},
+		{Time: start.Add(2 * time.Minute), Value: 50, Present: true},
+		{Time: start.Add(3 * time.Minute)},
+	}
+	if dataType != "http_req_rate" {
+		t.Errorf("KeySeries() data type = %s, want http_req_rate", dataType)
+	}
+	if diff := cmp.Diff(expectedPoints, points, cmp.Comparer(time.Time.Equal)); diff != "" {
+		t.Errorf("KeySeries() mismatch (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		limit    int
+		expected []HistoryTopKey
+	}{
+		{
+			name: "whole history",
+			from: start, to: start.Add(time.Hour),
+			expected: []HistoryTopKey{
+				{Key: "10.0.0.1", Peak: 50, PeakTime: start.Add(2 * time.Minute), Average: 27.5, Samples: 2},
+				{Key: "10.0.0.2", Peak: 40, PeakTime: start.Add(3 * time.Minute), Average: 30, Samples: 3},
+				{Key: "2001:db8::1", Peak: 40, PeakTime: start.Add(2 * time.Minute), Average: 40, Samples: 2},
+			},
+		},
+		{
+			name: "window with limit",
+			from: start.Add(2 * time.Minute), to: start.Add(2 * time.Minute), limit: 2,
+			expected: []HistoryTopKey{
+				{Key: "10.0.0.1", Peak: 50, PeakTime: start.Add(2 * time.Minute), Average: 50, Samples: 1},
+				{Key: "2001:db8::1", Peak: 40, PeakTime: start.Add(2 * time.Minute), Average: 40, Samples: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		_, rows, err := h.TopKeys("t1", tt.from, tt.to, tt.limit)
+		if err != nil {
+			t.Fatalf("TopKeys(%s) errored: %v", tt.name, err)
+		}
+		if diff := cmp.Diff(tt.expected, rows, cmp.Comparer(time.Time.Equal)); diff != "" {
+			t.Errorf("TopKeys(%s) mismatch (-want +got):\n%s", tt.name, diff)
+		}
+	}
+
+	if _, _, err := h.TopKeys("missing", start, start.Add(time.Hour), 0); err == nil {
+		t.Error("TopKeys() of a table without history didn't error")
+	}
+}
+
+func Test_HistoryConfigWrite(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "history.db")
+	cfg := HistoryConfig{Path: path}
+	if err := cfg.Write(context.Background(), testCollect(t)); err != nil {
+		t.Fatalf("Write() errored: %v", err)
+	}
+
+	// The database is closed after writing, so it can be opened by a reader
+	h, err := OpenHistory(path, true)
+	if err != nil {
+		t.Fatalf("OpenHistory() errored: %v", err)
+	}
+	defer h.Close()
+	dataType, rows, err := h.TopKeys("table_requests_limiter_src_ip", time.Now().Add(-time.Minute), time.Now(), 1)
+	if err != nil {
+		t.Fatalf("TopKeys() errored: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteTopKeys(&buf, dataType, rows); err != nil {
+		t.Fatalf("WriteTopKeys() errored: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("KEY          PEAK HTTP_REQ_RATE  PEAK TIME")) || !bytes.Contains(buf.Bytes(), []byte("1.39.115.67  2321")) {
+		t.Errorf("WriteTopKeys() = %s", buf.String())
+	}
+}
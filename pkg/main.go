@@ -2,84 +2,83 @@ package exporter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net"
+	"log/slog"
 	"net/netip"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
-
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-// Sends a command to HAProxy UNIX socket and returns the response
-func sendCommand(table string, socket string, storeType string, minRequestRate int, timeout time.Duration) (string, error) {
+// Sends a "show table" command to HAProxy UNIX socket over a new connection and returns the response
+func sendCommand(ctx context.Context, logger *slog.Logger, table string, socket string, storeType string, minRequestRate int, timeouts Timeouts) (string, error) {
+	if err := timeouts.Validate(); err != nil {
+		return "", err
+	}
+
+	return queryTable(ctx, NewClient(socket, timeouts, logger), Table{Name: table, DataType: storeType}, minRequestRate)
+}
+
+// Sends the "show table" command of Run for the table with the client and returns the response
+func queryTable(ctx context.Context, client *Client, table Table, minRequestRate int) (string, error) {
+	cmd, err := showTableCommand(table.Name, table.DataType, minRequestRate)
+	if err != nil {
+		return "", err
+	}
+
+	return client.Exec(ctx, cmd)
+}
+
+// Returns the "show table" command selecting the entries of a table with a value of storeType above minRequestRate
+func showTableCommand(table string, storeType string, minRequestRate int) (string, error) {
 	switch {
 	case storeType == "":
 		return "", fmt.Errorf("storeType argument cannot be empty")
 	case table == "":
 		return "", fmt.Errorf("table argument cannot be empty")
-	case socket == "":
-		return "", fmt.Errorf("socket argument cannot be empty")
-	case timeout < 0:
-		return "", fmt.Errorf("timeout argument can't be negative")
 	case minRequestRate < 0:
 		return "", fmt.Errorf("minRequestRate argument can't be negative")
 	}
-	var d net.Dialer
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	d.LocalAddr = nil
-	raddr := net.UnixAddr{Name: socket}
-	conn, err := d.DialContext(ctx, "unix", raddr.String())
-	if err != nil {
-		return "", fmt.Errorf("Failed to connect to %s UNIX socket: %v", socket, err)
-	}
-	defer conn.Close()
-
-	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+	if err := CheckArgument("table", table); err != nil {
 		return "", err
 	}
-	cmd := fmt.Sprintf("show table %s data.%s gt %d\n", table, storeType, minRequestRate)
-	if _, err := conn.Write([]byte(cmd)); err != nil {
-		return "", fmt.Errorf("Failed to send command to socket: %v", err)
+	if err := CheckArgument("storeType", storeType); err != nil {
+		return "", err
 	}
 
-	buf := make([]byte, 1024)
-	var data strings.Builder
-	for {
-		n, err := conn.Read(buf)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", fmt.Errorf("Error reading from socket: %v", err)
-		}
-		data.Write(buf[0:n])
-	}
-	r := strings.TrimSuffix(data.String(), "\n> ")
+	return fmt.Sprintf("show table %s data.%s gt %d", table, storeType, minRequestRate), nil
+}
+
+// Removes the prompt and the trailing empty line from a response
+func trimResponse(response string) string {
+	r := strings.TrimSuffix(response, "\n> ")
 	r = strings.TrimSuffix(r, "\n")
 	r = strings.TrimSpace(r)
 
-	return r, nil
+	return r
 }
 
-// Parses the response and returns a map of IP addresses to their request rates.
-func parse(response string, expectedStoreDataType string) (map[netip.Addr]int, error) {
+// Parsing modes of the entries of a stick-table
+const (
+	// ParseStrict fails on the first entry which can't be exported
+	ParseStrict = "strict"
+	// ParseLenient skips and counts the entries which can't be exported
+	ParseLenient = "lenient"
+)
+
+// Parses the response and returns a map of IP addresses to their request rates and the exported
+// entries, with the number of malformed lines which were skipped. In strict mode, an entry which
+// can't be exported fails the parsing while it is skipped in lenient mode.
+func parse(logger *slog.Logger, response string, expectedStoreDataType string, mode string, anonymizer *Anonymizer) (map[netip.Addr]int, []Entry, int, error) {
 
 	requests := make(map[netip.Addr]int)
 	if response == "" {
-		return nil, fmt.Errorf("Response is empty or malformed")
-	}
-
-	lines := strings.Split(response, "\n")
-	if len(lines) < 2 {
-		return requests, nil
+		return nil, nil, 0, &ParseError{Err: errEmptyResponse}
 	}
 
 	// Determine the stick table's data type.
@@ -92,118 +91,398 @@ func parse(response string, expectedStoreDataType string) (map[netip.Addr]int, e
 	// The response might include lines like:
 	// 0x7fcf0c057200: key=127.0.0.1 use=0 exp=58330 shard=0 conn_cnt=3 http_req_rate(60000)=3
 	//
-	// The current regex only supports tables with a single increment rate data type.
-	// Matches a line in this format:
-	// 0x7fcf0c057200: key=127.0.0.1 use=0 exp=58330 shard=0 http_req_rate(60000)=3
-	e := regexp.MustCompile(
-		`^` +
-			`\s*0x[[:alnum:]]+: ` + // Match the entry start with a hexadecimal address
-			`key=(?P<ip>[0-9a-fA-F:.]+) ` + // Match and capture the IP address; 1st group
-			`use=[[:digit:]]+ ` + // Match the use count
-			`exp=[[:digit:]]+ ` + // Match the expiration time
-			`shard=[[:digit:]]+` + // Match the shard value
-			`(?: gpc\d=\d+)? ` + // Optionally match gpc field
-			`(?P<storeType>[[:alnum:]_]+)` + // Match and capture the store type; 2nd group
-			`\([[:digit:]]+\)=(?P<rate>[[:digit:]]+)$`, // Match and capture the rate; 3rd group
-	)
-
-	for i := 0; i < len(lines); i++ {
-		m := e.FindStringSubmatch(lines[i])
-
-		if len(m) == 4 {
-			groups := make(map[string]string)
-			for i, name := range e.SubexpNames() {
-				if name != "" {
-					groups[name] = m[i]
-				}
-			}
-
-			storeType := groups["storeType"]
-			if storeType != expectedStoreDataType {
-				return nil, fmt.Errorf("Store type mismatch: expected '%s', but found '%s'", expectedStoreDataType, storeType)
+	// Only the value of the expected data type is kept.
+	lenient := mode == ParseLenient
+	entries, malformed, err := parseEntries(logger, response, lenient, anonymizer)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	exported := make([]Entry, 0, len(entries))
+	var sample error
+	for _, entry := range entries {
+		ip, rate, err := parseEntry(entry, expectedStoreDataType, requests)
+		if err != nil {
+			err = anonymizer.redact(err)
+			if !lenient {
+				return nil, nil, 0, err
 			}
-			ip, err := netip.ParseAddr(groups["ip"])
-			if err != nil {
-				return nil, fmt.Errorf("Failed to parse IP address: %v", err)
+			malformed++
+			if sample == nil {
+				sample = err
 			}
+			continue
+		}
 
-			rate, err := strconv.Atoi(groups["rate"])
-			if err != nil {
-				return nil, fmt.Errorf("Failed to parse rate: %v", err)
-			}
-			// This is highly unlikely to occur. If it does, it indicates a bug in HAProxy.
-			if _, ok := requests[ip]; ok {
-				return nil, fmt.Errorf("Duplicate key detected: %s", ip)
-			}
+		requests[ip] = rate
+		exported = append(exported, entry)
+	}
+	if lenient && malformed > 0 {
+		attrs := []any{"lines", malformed}
+		if sample != nil {
+			attrs = append(attrs, "sample", sample)
+		}
+		logger.Warn("Skipped malformed lines", attrs...)
+	}
+
+	return requests, exported, malformed, nil
+}
 
-			requests[ip] = rate
+// Returns the IP address and the value of the expected data type of an entry, checking
+// that the address isn't already in requests.
+func parseEntry(entry Entry, expectedStoreDataType string, requests map[netip.Addr]int) (netip.Addr, int, error) {
+	rate, ok := entry.Value(expectedStoreDataType)
+	if !ok {
+		var storeTypes []string
+		for _, d := range entry.Data {
+			storeTypes = append(storeTypes, d.Name)
 		}
+		err := fmt.Errorf("%w: expected '%s', but found '%s'", ErrStoreTypeMismatch, expectedStoreDataType, strings.Join(storeTypes, ","))
+		return netip.Addr{}, 0, &ParseError{Line: entry.Line, Raw: entry.Raw, Err: err}
+	}
+	ip, err := netip.ParseAddr(entry.Key)
+	if err != nil {
+		return netip.Addr{}, 0, &ParseError{Line: entry.Line, Raw: entry.Raw, Err: errors.New("Failed to parse IP address")}
+	}
+	// This is highly unlikely to occur. If it does, it indicates a bug in HAProxy.
+	if _, ok := requests[ip]; ok {
+		return netip.Addr{}, 0, &ParseError{Line: entry.Line, Raw: entry.Raw, Err: ErrDuplicateKey}
 	}
 
-	return requests, nil
+	return ip, rate, nil
 }
 
-// Check if the response is a stick-table of ip type and of expected name
-func validateHeader(response string, expectedTableName string) error {
-	lines := strings.Split(response, "\n")
+// TableHeader is the first line of a "show table" response
+type TableHeader struct {
+	Name string
+	Type string
+	Size int
+	Used int
+}
 
-	if len(lines) < 2 {
-		return fmt.Errorf("Response is empty or malformed")
+// Parses the header of a "show table" response
+func parseHeader(response string) (TableHeader, error) {
+	if response == "" {
+		return TableHeader{}, &ParseError{Err: errEmptyResponse}
 	}
 
-	header := lines[0]
+	header, _, _ := strings.Cut(response, "\n")
 	// The first line must look like the one below, yes it starts with a #
 	// # table: table_requests_limiter_src_ip, type: ip, size:1048576, used:2
-	r := regexp.MustCompile(`^#\s+table:\s*(?P<tableName>[\w\-.]+)\s*,\s*type:\s*(?P<tableType>[[:alpha:]]+),`)
+	r := regexp.MustCompile(
+		`^#\s+table:\s*(?P<tableName>[\w\-./]+)\s*,\s*type:\s*(?P<tableType>[[:alpha:]]+),` +
+			`(?:\s*size:\s*(?P<size>[[:digit:]]+)\s*,)?(?:\s*used:\s*(?P<used>[[:digit:]]+))?`,
+	)
 	m := r.FindStringSubmatch(header)
 
-	if len(m) != 3 {
-		return fmt.Errorf("Failed to parse table header, got '%s'", header)
+	if len(m) != 5 {
+		return TableHeader{}, &ParseError{Line: 1, Raw: header, Err: errors.New("Failed to parse table header")}
+	}
+
+	h := TableHeader{Name: m[1], Type: m[2]}
+	// size and used are optional, the regex guarantees they are numbers when present
+	if m[3] != "" {
+		h.Size, _ = strconv.Atoi(m[3])
+	}
+	if m[4] != "" {
+		h.Used, _ = strconv.Atoi(m[4])
+	}
+
+	return h, nil
+}
+
+// Check if the response is a stick-table of ip type and of expected name
+func validateHeader(response string, expectedTableName string) error {
+	h, err := parseHeader(response)
+	if err != nil {
+		return err
 	}
 
-	tableName := m[1]
-	tableType := m[2]
-	if tableName != expectedTableName {
-		return fmt.Errorf("Table name mismatch. Expected '%s', got '%s'", expectedTableName, tableName)
+	if h.Name != expectedTableName {
+		return fmt.Errorf("%w. Expected '%s', got '%s'", ErrHeaderMismatch, expectedTableName, h.Name)
 	}
-	if tableType != "ip" {
-		return fmt.Errorf("Unsupported table type '%s'. Only 'ip' type is supported", tableType)
+	if h.Type != "ip" {
+		return fmt.Errorf("%w '%s'. Only 'ip' type is supported", ErrUnsupportedTableType, h.Type)
 	}
 
 	return nil
 }
 
-// Run the exporter
-func Run(table string, socket string, minimumRequestRate int, prometheusFile string) error {
-	response, err := sendCommand(table, socket, "http_req_rate", minimumRequestRate, 1*time.Second)
+// Returns the period in milliseconds of a rate data type, e.g. 60000 for http_req_rate(60000)=3.
+// The period is only known when the response has at least one entry.
+func parsePeriod(response string, dataType string) (int, bool) {
+	r := regexp.MustCompile(` ` + regexp.QuoteMeta(dataType) + `\(([[:digit:]]+)\)=`)
+	m := r.FindStringSubmatch(response)
+	if m == nil {
+		return 0, false
+	}
+	period, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+
+	return period, true
+}
+
+// Table is a stick-table to query and the data type to export from it
+type Table struct {
+	// Name of the stick-table
+	Name string
+	// DataType is the data type to export, e.g. http_req_rate
+	DataType string
+}
+
+// Config holds the settings for a single run of the exporter.
+type Config struct {
+	// Tables are the stick-tables to query
+	Tables []Table
+	// Socket is the path to the HAProxy UNIX socket
+	Socket string
+	// Client sends the queries, a persistent client for Socket is created for every run when it is nil
+	Client *Client
+	// Timeouts bound the stages of every query of a stick-table
+	Timeouts Timeouts
+	// Retry retries the queries failing on a transient error
+	Retry RetryPolicy
+	// MinimumRequestRate filters out entries with a value equal or lower than it
+	MinimumRequestRate int
+	// Backends deliver the metrics, any of BackendTextfile, BackendPushgateway, BackendRemoteWrite,
+	// BackendStatsD, BackendOTLP, BackendNDJSON, BackendEvents and BackendHistory, textfile when empty
+	Backends []string
+	// PrometheusFile is the file the metrics are written to by the textfile backend
+	PrometheusFile string
+	// Push is where the pushgateway and remote-write backends push the metrics
+	Push PushConfig
+	// StatsD is where the statsd backend sends the metrics
+	StatsD StatsDConfig
+	// OTLP is where the otlp backend exports the metrics
+	OTLP OTLPConfig
+	// NDJSON is where the ndjson backend writes the entries
+	NDJSON NDJSONConfig
+	// Events is where the events backend sends the threshold crossings
+	Events EventsConfig
+	// History is where the history backend records the snapshots
+	History HistoryConfig
+	// OutputFormat is the format of PrometheusFile, OutputText or OutputOpenMetrics, text when empty
+	OutputFormat string
+	// Timestamps attaches the time every table was queried to its samples
+	Timestamps bool
+	// Relabel names and labels the metrics
+	Relabel RelabelConfig
+	// Anonymizer replaces the client IP addresses in every output, nil to keep them
+	Anonymizer *Anonymizer
+	// Counters turns the cumulative data types of the entries into counters, it needs the previous
+	// values so Daemon and Serve create one when it is nil, a single run doesn't export counters
+	Counters *CounterTracker
+	// Thresholds maps a stick-table name to the value of its data type above which HAProxy denies a client,
+	// e.g. 100 for `http-request deny if { sc_http_req_rate(0) gt 100 }`
+	Thresholds map[string]int
+	// MaxOverThresholdKeys bounds the number of over-threshold keys exported per table
+	MaxOverThresholdKeys int
+	// Expectations maps a stick-table name to how it is expected to be declared
+	Expectations map[string]TableExpectation
+	// OnMismatch is one of MismatchIgnore, MismatchWarn or MismatchFail
+	OnMismatch string
+	// ParseMode is ParseStrict or ParseLenient, strict when empty
+	ParseMode string
+	// FromFile is a response saved by Dump to use instead of querying the socket, for a single table
+	FromFile string
+	// Logger receives the logs of the run, slog.Default() is used when it is nil
+	Logger *slog.Logger
+}
+
+// minimumValue returns the value the entries of a table are filtered on. It is lowered to the
+// threshold of the table, e.g. 0 for `deny if { sc_get_gpc0(0) gt 0 }`, so the entries above
+// the threshold are all returned.
+func (cfg Config) minimumValue(table string) int {
+	minimum := cfg.MinimumRequestRate
+	if threshold, ok := cfg.Thresholds[table]; ok && threshold < minimum {
+		minimum = max(threshold, 0)
+	}
+	return minimum
+}
+
+// Run the exporter and delivers the metrics with every backend, ctx cancels the queries in progress.
+// A failing backend doesn't prevent the others from getting the metrics.
+func Run(ctx context.Context, cfg Config) error {
+	outputs, err := newOutputs(cfg)
 	if err != nil {
 		return err
 	}
-	if err := validateHeader(response, "table_requests_limiter_src_ip"); err != nil {
+	defer closeOutputs(outputs)
+	return runOnce(ctx, cfg, outputs)
+}
+
+// Daemon runs the exporter at every interval until ctx is canceled, over a session kept open
+// between runs. A failed run is logged and the next one happens at the next interval.
+func Daemon(ctx context.Context, cfg Config, interval time.Duration) error {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	outputs, err := newOutputs(cfg)
+	if err != nil {
 		return err
 	}
-	requests, err := parse(response, "http_req_rate")
+	defer closeOutputs(outputs)
+	if cfg.Counters == nil {
+		cfg.Counters = NewCounterTracker(DefaultCounterRetention)
+	}
+	if cfg.Client == nil && cfg.FromFile == "" {
+		cfg.Client = NewClient(cfg.Socket, cfg.Timeouts, logger.With("socket", cfg.Socket))
+		cfg.Client.Retry = cfg.Retry
+		cfg.Client.Persistent(1)
+		defer cfg.Client.Close()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := runOnce(ctx, cfg, outputs); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Error("Run failed", "error", err, "error_class", ErrorClass(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce queries the stick-tables and delivers their metrics with every output
+func runOnce(ctx context.Context, cfg Config, outputs []Output) error {
+	metricsExporter, err := Collect(ctx, cfg)
 	if err != nil {
 		return err
 	}
+	var errs []error
+	for _, output := range outputs {
+		if err := output.Write(ctx, metricsExporter); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Collect queries the stick-tables and returns their metrics, ctx cancels the queries in progress
+func Collect(ctx context.Context, cfg Config) (*StickTableExporter, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if err := cfg.Relabel.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.FromFile != "" {
+		logger = logger.With("file", cfg.FromFile)
+	} else {
+		logger = logger.With("socket", cfg.Socket)
+	}
 
-	metricsExporter := &StickTableExporter{
-		metric: promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "haproxy_stick_table",
-				Help: "Tracks the 'http_req_rate' per client IP address as observed by custom stick-table in HAProxy",
-			},
-			[]string{"client_ip", "name", "type"},
-		),
-		stickData: make(map[netip.Addr]int),
-		tableName: table,
+	client := cfg.Client
+	if client == nil {
+		// A single session in interactive mode is used for all the tables
+		client = NewClient(cfg.Socket, cfg.Timeouts, logger)
+		client.Retry = cfg.Retry
+		client.Persistent(1)
+		defer client.Close()
 	}
 
-	metricsExporter.UpdateData(requests)
-	if err := metricsExporter.WriteMetricsToFile(prometheusFile); err != nil {
-		fmt.Printf("Error writing metrics to file: %v\n", err)
-		os.Exit(1)
+	metricsExporter := NewStickTableExporter(cfg.MaxOverThresholdKeys, logger)
+	if cfg.Timestamps {
+		metricsExporter.EnableTimestamps()
+	}
+	metricsExporter.SetRelabeling(cfg.Relabel)
+	metricsExporter.SetAnonymizer(cfg.Anonymizer)
+	for _, table := range cfg.Tables {
+		start := time.Now()
+		tableLogger := logger.With("table", table.Name, "data_type", table.DataType)
+		var response string
+		var err error
+		if cfg.FromFile != "" {
+			response, err = readResponse(tableLogger, cfg.FromFile)
+		} else {
+			// The client is shared by the concurrent scrapes, the attempts are counted per query
+			var attempts atomic.Int64
+			response, err = queryTable(withAttemptCounter(ctx, &attempts), client, table, cfg.minimumValue(table.Name))
+			metricsExporter.SetQueryAttempts(table.Name, attempts.Load())
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := validateHeader(response, table.Name); err != nil {
+			return nil, err
+		}
+		requests, entries, malformed, err := parse(tableLogger, response, table.DataType, cfg.ParseMode, cfg.Anonymizer)
+		if err != nil {
+			return nil, err
+		}
+		header, err := parseHeader(response)
+		if err != nil {
+			return nil, err
+		}
+		period, hasPeriod := parsePeriod(response, table.DataType)
+		if e, ok := cfg.Expectations[table.Name]; ok && cfg.OnMismatch != MismatchIgnore {
+			if diffs := checkExpectation(e, header, period, hasPeriod); len(diffs) > 0 {
+				err := mismatchError(table.Name, diffs)
+				if cfg.OnMismatch == MismatchFail {
+					return nil, err
+				}
+				tableLogger.Warn("Stick-table differs from its expectation", "differences", diffs)
+			}
+		}
+		metricsExporter.SetInfo(table.Name, table.DataType, header, period, hasPeriod)
+
+		if threshold, ok := cfg.Thresholds[table.Name]; ok {
+			metricsExporter.SetThreshold(table.Name, threshold)
+		}
+		metricsExporter.SetMalformedLines(table.Name, malformed)
+		metricsExporter.SetQueried(table.Name, start, time.Since(start))
+		metricsExporter.SetEntries(table.Name, header, entries)
+		if cfg.Counters != nil {
+			metricsExporter.SetCounters(table.Name, cfg.Counters.Update(table.Name, start, entries))
+		}
+		metricsExporter.UpdateData(table.Name, table.DataType, requests)
+		tableLogger.Debug("Queried stick-table", "entries", len(requests), "used", header.Used, "duration", time.Since(start))
+	}
+
+	return metricsExporter, nil
+}
+
+// Reads a response saved by Dump
+func readResponse(logger *slog.Logger, filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read response from file: %v", err)
+	}
+	logger.Debug("Read response from file", "file", filename, "bytes", len(data))
+
+	return trimResponse(string(data)), nil
+}
+
+// Dump writes the response of HAProxy to the command Run sends for the table as received,
+// so it can be replayed later with Config.FromFile.
+func Dump(ctx context.Context, client *Client, table Table, minimumRequestRate int, w io.Writer) error {
+	logger := client.logger().With("table", table.Name, "data_type", table.DataType)
+	cmd, err := showTableCommand(table.Name, table.DataType, minimumRequestRate)
+	if err != nil {
+		return err
+	}
+	response, err := client.ExecRaw(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if err := runtimeError(cmd, trimResponse(response)); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, response); err != nil {
+		return fmt.Errorf("Failed to write response: %v", err)
 	}
+	logger.Info("Dumped stick-table", "bytes", len(response))
 
 	return nil
 }
@@ -1,7 +1,11 @@
 package exporter
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"net/netip"
 	"os"
@@ -11,8 +15,12 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
+// testLogger discards the logs of the functions under test
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
 func Test_sendCommand(t *testing.T) {
 	t.Parallel()
 	tmpDir, err := os.MkdirTemp("", "sendCommand-test")
@@ -32,6 +40,7 @@ func Test_sendCommand(t *testing.T) {
 		wantErr           bool
 		connectionFailure bool
 		expectedErr       string
+		expectedErrIs     error
 	}{
 		{
 			name:           "valid input",
@@ -51,16 +60,16 @@ func Test_sendCommand(t *testing.T) {
 			minRequestRate:    1,
 			timeout:           1 * time.Second,
 			wantErr:           true,
-			expectedErr:       "Failed to connect to",
+			expectedErrIs:     ErrConnect,
 			connectionFailure: true,
 		},
 		{
-			name:        "connection timeout",
-			table:       "table_requests_limiter_src_ip",
-			storeType:   "http_req_rate",
-			timeout:     0 * time.Nanosecond,
-			wantErr:     true,
-			expectedErr: "Failed to connect to",
+			name:          "connection timeout",
+			table:         "table_requests_limiter_src_ip",
+			storeType:     "http_req_rate",
+			timeout:       1 * time.Nanosecond,
+			wantErr:       true,
+			expectedErrIs: ErrTimeout,
 		},
 		{
 			name:        "empty storeType",
@@ -138,11 +147,15 @@ func Test_sendCommand(t *testing.T) {
 				}()
 			}
 
-			got, err := sendCommand(tt.table, socket, tt.storeType, tt.minRequestRate, tt.timeout)
+			got, err := sendCommand(context.Background(), testLogger, tt.table, socket, tt.storeType, tt.minRequestRate, Timeouts{Overall: tt.timeout})
 			// Check error cases
 			if tt.wantErr != (err != nil) {
 				t.Errorf("errored = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.expectedErrIs != nil && !errors.Is(err, tt.expectedErrIs) {
+				t.Errorf("error --%v--, want --%v--", err, tt.expectedErrIs)
+				return
+			}
 			if tt.wantErr && err != nil && !strings.HasPrefix(err.Error(), tt.expectedErr) {
 				t.Errorf("error message  --%v--, want something which starts with --%v--", err.Error(), tt.expectedErr)
 				return
@@ -170,9 +183,12 @@ func Test_parse(t *testing.T) {
 		name                  string
 		input                 string
 		wantErr               bool
-		expectedErr           string
+		expectedErr           error
+		expectedLine          int
 		expectedStoreDataType string
 		expected              map[netip.Addr]int
+		parseMode             string
+		expectedMalformed     int
 	}{
 		{
 			name: "valid input",
@@ -188,8 +204,7 @@ func Test_parse(t *testing.T) {
 				m[addr2] = 2321
 				return m
 			}(),
-			wantErr:     false,
-			expectedErr: "",
+			wantErr: false,
 		},
 		{
 			name:                  "valid input without entries",
@@ -197,7 +212,6 @@ func Test_parse(t *testing.T) {
 			expectedStoreDataType: "http_req_rate",
 			expected:              map[netip.Addr]int{},
 			wantErr:               false,
-			expectedErr:           "",
 		},
 		{
 			name: "invalid input with missing key", // we skip that entry and return valid response
@@ -211,8 +225,8 @@ func Test_parse(t *testing.T) {
 				m[addr1] = 1
 				return m
 			}(),
-			wantErr:     false,
-			expectedErr: "",
+			expectedMalformed: 1,
+			wantErr:           false,
 		},
 		{
 			name: "invalid input with incorrect store type",
@@ -223,7 +237,8 @@ func Test_parse(t *testing.T) {
 			expectedStoreDataType: "http_req_rate",
 			expected:              nil,
 			wantErr:               true,
-			expectedErr:           "Store type mismatch",
+			expectedErr:           ErrStoreTypeMismatch,
+			expectedLine:          4,
 		},
 		{
 			name: "invalid input with incorrect IP address",
@@ -234,7 +249,8 @@ func Test_parse(t *testing.T) {
 			expectedStoreDataType: "http_req_rate",
 			expected:              nil,
 			wantErr:               true,
-			expectedErr:           "Failed to parse IP",
+			expectedErr:           ErrParse,
+			expectedLine:          3,
 		},
 		{
 			name: "invalid input with incorrect rate",
@@ -249,8 +265,24 @@ func Test_parse(t *testing.T) {
 				m[addr1] = 1
 				return m
 			}(),
-			wantErr:     false,
-			expectedErr: "",
+			expectedMalformed: 2,
+			wantErr:           false,
+		},
+		{
+			name: "valid input with multiple data types",
+			input: "# table: table_requests_limiter_src_ip, type: ip, size:1048576, used:11597\n" +
+				"0x7f6d48298b70: key=1.32.20.122 use=0 exp=26834 shard=0 conn_cnt=3 http_req_rate(60000)=1\n" +
+				"0x55e0d8f5cc20: key=1.39.115.67 use=0 exp=44496 shard=0 gpc0=1 conn_cnt=7 http_req_rate(60000)=2321",
+			expectedStoreDataType: "conn_cnt",
+			expected: func() map[netip.Addr]int {
+				m := make(map[netip.Addr]int)
+				addr1, _ := netip.ParseAddr("1.32.20.122")
+				addr2, _ := netip.ParseAddr("1.39.115.67")
+				m[addr1] = 3
+				m[addr2] = 7
+				return m
+			}(),
+			wantErr: false,
 		},
 		{
 			name: "valid input with high rate",
@@ -266,13 +298,30 @@ func Test_parse(t *testing.T) {
 				m[addr2] = 100000000000000
 				return m
 			}(),
-			wantErr:     false,
-			expectedErr: "",
+			wantErr: false,
+		},
+		{
+			name: "lenient mode skips entries which can't be exported",
+			input: "# table: table_requests_limiter_src_ip, type: ip, size:1048576, used:11597\n" +
+				"0x7f6d48298b70: key=1.32.20.122 use=0 exp=26834 shard=0 http_req_rate(60000)=1\n" +
+				"0x55e0d8f5cc20: key=11.3 use=0 exp=44496 shard=0 http_req_rate(60000)=2321\n" +
+				"0x55e0d8f5cc20: key=1.39.115.67 use=0 exp=44496 shard=0 httpfoo_req_rate(60000)=2321\n" +
+				"0x55e0d8f5cc20: key=1.32.20.122 use=0 exp=44496 shard=0 http_req_rate(60000)=2321\n" +
+				"0x55e0d8f5cc20: use=0 exp=44496 shard=0 http_req_rate(60000)=2321",
+			expectedStoreDataType: "http_req_rate",
+			parseMode:             ParseLenient,
+			expected: func() map[netip.Addr]int {
+				m := make(map[netip.Addr]int)
+				addr1, _ := netip.ParseAddr("1.32.20.122")
+				m[addr1] = 1
+				return m
+			}(),
+			expectedMalformed: 4,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			requests, err := parse(tt.input, tt.expectedStoreDataType)
+			requests, entries, malformed, err := parse(testLogger, tt.input, tt.expectedStoreDataType, tt.parseMode, nil)
 			// Check error cases
 			if tt.wantErr != (err != nil) {
 				t.Errorf("errored = %v, wantErr %v", err, tt.wantErr)
@@ -282,11 +331,21 @@ func Test_parse(t *testing.T) {
 				if diff := cmp.Diff(tt.expected, requests); diff != "" {
 					t.Error(diff)
 				}
+				if len(entries) != len(tt.expected) {
+					t.Errorf("parse() returned %d entries, want %d", len(entries), len(tt.expected))
+				}
+				if malformed != tt.expectedMalformed {
+					t.Errorf("parse() skipped %d malformed lines, want %d", malformed, tt.expectedMalformed)
+				}
 			}
 			// If we expect an error, verify the error message
 			if tt.wantErr {
-				if err == nil {
-					t.Errorf("expected error message = %v, got nil", tt.expectedErr)
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("error --%v--, want --%v--", err, tt.expectedErr)
+				}
+				var parseErr *ParseError
+				if !errors.As(err, &parseErr) || parseErr.Line != tt.expectedLine {
+					t.Errorf("error --%v--, want a ParseError at line %d", err, tt.expectedLine)
 				}
 			}
 		})
@@ -299,7 +358,7 @@ func Test_validateHeader(t *testing.T) {
 		input             string
 		expectedTableName string
 		wantErr           bool
-		expectedErr       string
+		expectedErr       error
 	}{
 		{
 			name: "valid input",
@@ -308,14 +367,13 @@ func Test_validateHeader(t *testing.T) {
 				"0x55e0d8f5cc20: key=1.39.115.67 use=0 exp=44496 shard=0 http_req_rate(60000)=2321",
 			expectedTableName: "table_requests_limiter_src_ip",
 			wantErr:           false,
-			expectedErr:       "",
 		},
 		{
 			name:              "empty input",
 			input:             "",
 			expectedTableName: "table_requests_limiter_src_ip",
 			wantErr:           true,
-			expectedErr:       "Response is empty",
+			expectedErr:       ErrParse,
 		},
 		{
 			name: "invalid format with missing type",
@@ -324,7 +382,7 @@ func Test_validateHeader(t *testing.T) {
 				"0x55e0d8f5cc20: key=1.39.115.67 use=0 exp=44496 shard=0 http_req_rate(60000)=2321",
 			expectedTableName: "table_requests_limiter_src_ip",
 			wantErr:           true,
-			expectedErr:       "Failed to parse",
+			expectedErr:       ErrParse,
 		},
 		{
 			name: "valid input with wrong table name",
@@ -333,7 +391,7 @@ func Test_validateHeader(t *testing.T) {
 				"0x55e0d8f5cc20: key=1.39.115.67 use=0 exp=44496 shard=0 http_req_rate(60000)=2321",
 			expectedTableName: "table_requests_limiter_src_ip",
 			wantErr:           true,
-			expectedErr:       "Table",
+			expectedErr:       ErrHeaderMismatch,
 		},
 		{
 			name: "valid input with wrong type",
@@ -342,14 +400,14 @@ func Test_validateHeader(t *testing.T) {
 				"0x55e0d8f5cc20: key=1.39.115.67 use=0 exp=44496 shard=0 http_req_rate(60000)=2321",
 			expectedTableName: "table_requests_limiter_src_ip",
 			wantErr:           true,
-			expectedErr:       "Unsupported table type",
+			expectedErr:       ErrUnsupportedTableType,
 		},
 		{
 			name:              "empty input with newline character",
 			input:             "\n",
 			expectedTableName: "table_requests_limiter_src_ip",
 			wantErr:           true,
-			expectedErr:       "Failed to parse table header",
+			expectedErr:       ErrParse,
 		},
 	}
 	for _, tt := range tests {
@@ -365,8 +423,8 @@ func Test_validateHeader(t *testing.T) {
 				if err == nil {
 					t.Errorf("expected error message = %v, got nil", tt.expectedErr)
 				}
-				if !strings.HasPrefix(err.Error(), tt.expectedErr) {
-					t.Errorf("error message  --%v--, want something which starts with --%v--", err.Error(), tt.expectedErr)
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("error --%v--, want --%v--", err, tt.expectedErr)
 				}
 			}
 		})
@@ -387,3 +445,139 @@ func Fuzz_validateHeader(f *testing.F) {
 		}
 	})
 }
+func Test_parseHeader(t *testing.T) {
+	t.Parallel()
+	input := "# table: mypeers/sessions, type: ip, size:1048576, used:11597\n" +
+		"0x7f6d48298b70: key=1.32.20.122 use=0 exp=26834 shard=0 conn_cnt=3 http_req_rate(60000)=1"
+	expected := TableHeader{Name: "mypeers/sessions", Type: "ip", Size: 1048576, Used: 11597}
+	header, err := parseHeader(input)
+	if err != nil {
+		t.Fatalf("parseHeader() errored: %v", err)
+	}
+	if diff := cmp.Diff(expected, header); diff != "" {
+		t.Error(diff)
+	}
+
+	period, ok := parsePeriod(input, "http_req_rate")
+	if !ok || period != 60000 {
+		t.Errorf("parsePeriod() = %d, %v, want 60000, true", period, ok)
+	}
+	if _, ok := parsePeriod(input, "conn_cnt"); ok {
+		t.Errorf("parsePeriod() found a period for conn_cnt")
+	}
+}
+
+func Test_RunFromFile(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		file         string
+		parseMode    string
+		outputFormat string
+		wantErr      bool
+		expectedErr  error
+		expected     []string
+	}{
+		{
+			name: "multiple data types",
+			file: "table_requests_limiter_src_ip.dump",
+			expected: []string{
+				`haproxy_stick_table{client_ip="1.32.20.122",data_type="http_req_rate",name="table_requests_limiter_src_ip",type="ip"} 1`,
+				`haproxy_stick_table{client_ip="1.39.115.67",data_type="http_req_rate",name="table_requests_limiter_src_ip",type="ip"} 2321`,
+				`haproxy_stick_table{client_ip="2001:db8::1",data_type="http_req_rate",name="table_requests_limiter_src_ip",type="ip"} 150`,
+				`haproxy_stick_table_info{data_type="http_req_rate",name="table_requests_limiter_src_ip",period_ms="60000",size="1048576",type="ip"} 1`,
+			},
+		},
+		{
+			name: "response with prompt",
+			file: "prompt.dump",
+			expected: []string{
+				`haproxy_stick_table{client_ip="1.32.20.122",data_type="http_req_rate",name="table_requests_limiter_src_ip",type="ip"} 7`,
+			},
+		},
+		{
+			name:        "duplicate key",
+			file:        "duplicate_key.dump",
+			wantErr:     true,
+			expectedErr: ErrDuplicateKey,
+		},
+		{
+			name:      "duplicate key in lenient mode",
+			file:      "duplicate_key.dump",
+			parseMode: ParseLenient,
+			expected: []string{
+				`haproxy_stick_table{client_ip="1.32.20.122",data_type="http_req_rate",name="table_requests_limiter_src_ip",type="ip"} 1`,
+				`haproxy_stick_table_malformed_lines{name="table_requests_limiter_src_ip"} 1`,
+			},
+		},
+		{
+			name:         "openmetrics format",
+			file:         "prompt.dump",
+			outputFormat: OutputOpenMetrics,
+			expected: []string{
+				`haproxy_stick_table{client_ip="1.32.20.122",data_type="http_req_rate",name="table_requests_limiter_src_ip",type="ip"} 7.0`,
+				"# UNIT haproxy_stick_table_query_duration_seconds seconds",
+				"# EOF",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prometheusFile := filepath.Join(t.TempDir(), "metrics.prom")
+			err := Run(context.Background(), Config{
+				Tables:         []Table{{Name: "table_requests_limiter_src_ip", DataType: "http_req_rate"}},
+				PrometheusFile: prometheusFile,
+				FromFile:       filepath.Join("testdata", tt.file),
+				ParseMode:      tt.parseMode,
+				OutputFormat:   tt.outputFormat,
+				Logger:         testLogger,
+			})
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("errored = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("error --%v--, want --%v--", err, tt.expectedErr)
+				}
+				return
+			}
+
+			output, err := os.ReadFile(prometheusFile)
+			if err != nil {
+				t.Fatalf("Failed to read metrics: %v", err)
+			}
+			for _, line := range tt.expected {
+				if !strings.Contains(string(output), line+"\n") {
+					t.Errorf("metrics don't contain %s, got:\n%s", line, output)
+				}
+			}
+		})
+	}
+}
+
+func Test_CollectThresholdBelowMinimum(t *testing.T) {
+	t.Parallel()
+	socket := startTestServer(t, map[string]string{
+		"show table be_app data.gpc0 gt 0": "# table: be_app, type: ip, size:1048576, used:1\n" +
+			"0x7f6d48298b70: key=10.0.0.1 use=0 exp=26834 shard=0 gpc0=1\n",
+	})
+	// The threshold taken from `deny if { src_get_gpc0 gt 0 }` is lower than the default minimum
+	e, err := Collect(context.Background(), Config{
+		Tables:             []Table{{Name: "be_app", DataType: "gpc0"}},
+		Socket:             socket,
+		MinimumRequestRate: 1,
+		Thresholds:         map[string]int{"be_app": 0},
+		Logger:             testLogger,
+	})
+	if err != nil {
+		t.Fatalf("Collect() errored: %v", err)
+	}
+	expected := `
+# HELP haproxy_stick_table_entries_over_threshold Number of entries in a stick-table with a value above the configured threshold
+# TYPE haproxy_stick_table_entries_over_threshold gauge
+haproxy_stick_table_entries_over_threshold{name="be_app"} 1
+`
+	if err := testutil.GatherAndCompare(e.Gatherer(), strings.NewReader(expected), "haproxy_stick_table_entries_over_threshold"); err != nil {
+		t.Error(err)
+	}
+}
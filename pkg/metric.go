@@ -1,46 +1,312 @@
 package exporter
 
 import (
+	"fmt"
+	"log/slog"
 	"net/netip"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Handles the prometheus metrics export for HAProxy stick table data.
-// It maintains a gauge vector metric for tracking client IP addresses and their associated values.
+// It maintains a gauge vector metric for tracking client IP addresses and their associated values
+// across one or more stick-tables.
 type StickTableExporter struct {
 	// metric is the prometheus gauge vector for stick table data
 	metric *prometheus.GaugeVec
+	// info describes the declaration of every stick table
+	info *prometheus.GaugeVec
+	// overThreshold is the number of entries with a value above threshold
+	overThreshold *prometheus.GaugeVec
+	// overThresholdKeys holds the entries with the highest values above threshold
+	overThresholdKeys *prometheus.GaugeVec
+	// malformedLines is the number of lines skipped in the response of every stick table
+	malformedLines *prometheus.GaugeVec
+	// queryDuration is the time it took to query and parse every stick table
+	queryDuration *prometheus.GaugeVec
+	// queryAttempts is the number of attempts it took to query every stick table, retries included
+	queryAttempts *prometheus.GaugeVec
+	// counter is the value of the cumulative data types per client IP, kept increasing across expiries
+	counter *prometheus.CounterVec
+	// counterDelta is the increase of the cumulative data types per client IP since the previous query
+	counterDelta *prometheus.GaugeVec
+	// maxOverThresholdKeys bounds the number of keys exported in overThresholdKeys per table
+	maxOverThresholdKeys int
+	// tables holds the current state of every stick table, indexed by name
+	tables map[string]*tableData
+	// queriedAt holds the time every stick table was queried, indexed by name
+	queriedAt map[string]time.Time
+	// timestamps attaches the time a table was queried to its samples
+	timestamps bool
+	// relabel names and labels the metrics
+	relabel RelabelConfig
+	// anonymizer replaces the client IP addresses in every output, nil to keep them
+	anonymizer *Anonymizer
+	logger     *slog.Logger
+}
+
+// tableData is the state of a single stick table
+type tableData struct {
+	// dataType is the data type the values are taken from, e.g. http_req_rate
+	dataType string
 	// stickData holds the current state of client IPs and their values
 	stickData map[netip.Addr]int
-	// tableName is the name of the HAProxy stick table
-	tableName string
+	// threshold is the value above which HAProxy denies a client, only used when hasThreshold is true
+	threshold    int
+	hasThreshold bool
+	// header is the header of the response of the table
+	header TableHeader
+	// entries are the parsed entries the values are taken from
+	entries []Entry
+}
+
+// NewStickTableExporter returns an exporter which exports up to maxOverThresholdKeys
+// over-threshold entries per table.
+func NewStickTableExporter(maxOverThresholdKeys int, logger *slog.Logger) *StickTableExporter {
+	e := &StickTableExporter{
+		maxOverThresholdKeys: maxOverThresholdKeys,
+		tables:               make(map[string]*tableData),
+		queriedAt:            make(map[string]time.Time),
+		logger:               logger,
+	}
+	e.newMetrics()
+	return e
+}
+
+// newMetrics creates the metrics, named and labeled after the relabel config
+func (e *StickTableExporter) newMetrics() {
+	r := e.relabel
+	gaugeVec := func(name string, help string, labels ...string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Name: r.metricName(name), Help: help, ConstLabels: r.ExtraLabels},
+			r.labelNames(labels...),
+		)
+	}
+	e.metric = gaugeVec("",
+		"Tracks the value of a data type, e.g. 'http_req_rate', per client IP address as observed by custom stick-table in HAProxy",
+		"client_ip", "name", "type", "data_type")
+	e.info = gaugeVec("info",
+		"Declaration of a stick-table as reported by HAProxy, period_ms is empty when the table has no entries",
+		"name", "type", "size", "data_type", "period_ms")
+	e.overThreshold = gaugeVec("entries_over_threshold",
+		"Number of entries in a stick-table with a value above the configured threshold",
+		"name")
+	e.overThresholdKeys = gaugeVec("over_threshold_key",
+		"Value of the entries above the configured threshold, limited to the highest ones",
+		"client_ip", "name")
+	e.malformedLines = gaugeVec("malformed_lines",
+		"Number of lines of a stick-table which couldn't be parsed and were skipped",
+		"name")
+	e.queryDuration = gaugeVec("query_duration_seconds",
+		"Time it took to query and parse a stick-table",
+		"name")
+	e.queryAttempts = gaugeVec("query_attempts",
+		"Number of attempts it took to query a stick-table, above 1 when transient errors were retried",
+		"name")
+	e.counter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        r.metricName("counter_total"),
+			Help:        "Value of a cumulative data type, e.g. 'http_req_cnt', per client IP address, which keeps increasing when the entry expires",
+			ConstLabels: r.ExtraLabels,
+		},
+		r.labelNames("client_ip", "name", "type", "data_type"),
+	)
+	e.counterDelta = gaugeVec("counter_delta",
+		"Increase of a cumulative data type per client IP address since the previous query of the stick-table",
+		"client_ip", "name", "type", "data_type")
+}
+
+// SetRelabeling names and labels the metrics after the config, which must be valid. It must be
+// called before any value is set, the metrics are created again.
+func (e *StickTableExporter) SetRelabeling(relabel RelabelConfig) {
+	e.relabel = relabel
+	e.newMetrics()
+}
+
+// SetAnonymizer replaces the client IP addresses with anonymized keys in every output, the key
+// rules of the relabeling apply to the anonymized keys
+func (e *StickTableExporter) SetAnonymizer(a *Anonymizer) {
+	e.anonymizer = a
+}
+
+// key returns the value of the client_ip label of an address
+func (e *StickTableExporter) key(ip netip.Addr) string {
+	return e.relabel.key(e.anonymizer.Key(ip))
+}
+
+// table returns the state of the named table, creating it when needed
+func (e *StickTableExporter) table(name string) *tableData {
+	t, ok := e.tables[name]
+	if !ok {
+		t = &tableData{stickData: make(map[netip.Addr]int)}
+		e.tables[name] = t
+	}
+	return t
+}
+
+// SetInfo exports the type and size of a table from its header, and the period in
+// milliseconds of its data type when it is known.
+func (e *StickTableExporter) SetInfo(table string, dataType string, header TableHeader, period int, hasPeriod bool) {
+	periodLabel := ""
+	if hasPeriod {
+		periodLabel = strconv.Itoa(period)
+	}
+	e.info.WithLabelValues(table, header.Type, strconv.Itoa(header.Size), dataType, periodLabel).Set(1)
+}
+
+// SetThreshold enables the over-threshold metrics of a table. Entries with a value greater
+// than threshold are counted and the highest of them are exported with their client IP address.
+func (e *StickTableExporter) SetThreshold(table string, threshold int) {
+	t := e.table(table)
+	t.threshold = threshold
+	t.hasThreshold = true
+}
+
+// SetEntries keeps the header and the parsed entries of a table for the outputs which
+// export whole entries rather than a single data type
+func (e *StickTableExporter) SetEntries(table string, header TableHeader, entries []Entry) {
+	t := e.table(table)
+	t.header = header
+	t.entries = entries
+}
+
+// SetCounters exports the counters of the cumulative data types of a table
+func (e *StickTableExporter) SetCounters(table string, values []CounterValue) {
+	for _, v := range values {
+		key := e.relabel.key(e.anonymizer.EntryKey(v.Key))
+		// Keys rewritten to the same value are summed
+		e.counter.WithLabelValues(key, table, "ip", v.DataType).Add(float64(v.Total))
+		e.counterDelta.WithLabelValues(key, table, "ip", v.DataType).Add(float64(v.Delta))
+	}
+}
+
+// SetMalformedLines exports the number of lines skipped when parsing a table
+func (e *StickTableExporter) SetMalformedLines(table string, lines int) {
+	e.malformedLines.WithLabelValues(table).Set(float64(lines))
+}
+
+// SetQueried records when a table was queried and exports the time it took
+func (e *StickTableExporter) SetQueried(table string, at time.Time, duration time.Duration) {
+	e.queriedAt[table] = at
+	e.queryDuration.WithLabelValues(table).Set(duration.Seconds())
+}
+
+// EnableTimestamps attaches the time a table was queried to its samples, so they reflect when HAProxy
+// was queried rather than when they are scraped. The textfile collector of node_exporter rejects them.
+func (e *StickTableExporter) EnableTimestamps() {
+	e.timestamps = true
+}
+
+// SetQueryAttempts exports the number of attempts it took to query a table
+func (e *StickTableExporter) SetQueryAttempts(table string, attempts int64) {
+	e.queryAttempts.WithLabelValues(table).Set(float64(attempts))
 }
 
 // UpdateMetrics updates the prometheus gauge vector with the current stick table data.
 // For each IP address in stickData, it creates a metric with labels for client_ip,
-// name, and type (fixed as "ip").
+// name, type (fixed as "ip") and data_type. The values of the keys rewritten to the
+// same value by the key rules are summed.
 func (e *StickTableExporter) UpdateMetrics() {
-	for ip, value := range e.stickData {
-		e.metric.WithLabelValues(
-			ip.String(),
-			e.tableName,
-			"ip",
-		).Set(float64(value))
+	for name, t := range e.tables {
+		values := make(map[string]int, len(t.stickData))
+		for ip, value := range t.stickData {
+			values[e.key(ip)] += value
+		}
+		for key, value := range values {
+			e.metric.WithLabelValues(
+				key,
+				name,
+				"ip",
+				t.dataType,
+			).Set(float64(value))
+		}
+		if t.hasThreshold {
+			e.updateThresholdMetrics(name, t)
+		}
 	}
 }
 
-// UpdateData updates the StickTableExporter's internal stick table data
-func (e *StickTableExporter) UpdateData(newData map[netip.Addr]int) {
-	e.stickData = newData
+// updateThresholdMetrics sets the number of entries above the threshold and
+// exports the highest of them, ties are broken by IP address to keep the output stable.
+func (e *StickTableExporter) updateThresholdMetrics(name string, t *tableData) {
+	var over []netip.Addr
+	for ip, value := range t.stickData {
+		if value > t.threshold {
+			over = append(over, ip)
+		}
+	}
+	e.overThreshold.WithLabelValues(name).Set(float64(len(over)))
+
+	sort.Slice(over, func(i, j int) bool {
+		vi, vj := t.stickData[over[i]], t.stickData[over[j]]
+		if vi != vj {
+			return vi > vj
+		}
+		return over[i].Less(over[j])
+	})
+	if len(over) > e.maxOverThresholdKeys {
+		over = over[:e.maxOverThresholdKeys]
+	}
+	exported := make(map[string]bool, len(over))
+	for _, ip := range over {
+		// The threshold applies to every key, a rewritten key has the highest value of its keys
+		key := e.key(ip)
+		if exported[key] {
+			continue
+		}
+		exported[key] = true
+		e.overThresholdKeys.WithLabelValues(key, name).Set(float64(t.stickData[ip]))
+	}
+}
+
+// UpdateData updates the StickTableExporter's internal data of a stick table
+func (e *StickTableExporter) UpdateData(table string, dataType string, newData map[netip.Addr]int) {
+	t := e.table(table)
+	t.dataType = dataType
+	t.stickData = newData
 	e.UpdateMetrics()
 }
 
+// Gatherer returns the metrics of all the tables
+func (e *StickTableExporter) Gatherer() prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	for _, c := range []prometheus.Collector{
+		e.metric, e.info, e.overThreshold, e.overThresholdKeys, e.malformedLines, e.queryDuration, e.queryAttempts,
+		e.counter, e.counterDelta,
+	} {
+		if e.timestamps {
+			c = timestampCollector{Collector: c, queriedAt: e.queriedAt, tableLabel: e.relabel.labelName("name")}
+		}
+		registry.MustRegister(c)
+	}
+
+	return unitGatherer{registry}
+}
+
 // WriteMetricsToFile writes the current metrics to the specified file in Prometheus text format.
 func (e *StickTableExporter) WriteMetricsToFile(filename string) error {
-	// Create a new registry
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(e.metric)
+	return e.WriteMetrics(filename, OutputText)
+}
+
+// WriteMetrics writes the current metrics to the specified file in the format, OutputText or
+// OutputOpenMetrics, text when empty.
+func (e *StickTableExporter) WriteMetrics(filename string, format string) error {
+	var err error
+	switch format {
+	case OutputText, "":
+		err = prometheus.WriteToTextfile(filename, e.Gatherer())
+	case OutputOpenMetrics:
+		err = writeOpenMetrics(filename, e.Gatherer())
+	default:
+		err = fmt.Errorf("Unsupported output format '%s'", format)
+	}
+	if err != nil {
+		return err
+	}
+	e.logger.Debug("Wrote metrics", "file", filename, "format", format, "tables", len(e.tables))
 
-	return prometheus.WriteToTextfile(filename, registry)
+	return nil
 }
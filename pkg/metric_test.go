@@ -0,0 +1,40 @@
+package exporter
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_updateThresholdMetrics(t *testing.T) {
+	t.Parallel()
+	e := NewStickTableExporter(2, testLogger)
+	e.SetThreshold("table_requests_limiter_src_ip", 100)
+	e.UpdateData("table_requests_limiter_src_ip", "http_req_rate", map[netip.Addr]int{
+		netip.MustParseAddr("1.1.1.1"): 100,
+		netip.MustParseAddr("2.2.2.2"): 101,
+		netip.MustParseAddr("3.3.3.3"): 500,
+		netip.MustParseAddr("4.4.4.4"): 300,
+		netip.MustParseAddr("5.5.5.5"): 2,
+	})
+
+	expected := `
+# HELP haproxy_stick_table_entries_over_threshold Number of entries in a stick-table with a value above the configured threshold
+# TYPE haproxy_stick_table_entries_over_threshold gauge
+haproxy_stick_table_entries_over_threshold{name="table_requests_limiter_src_ip"} 3
+`
+	if err := testutil.CollectAndCompare(e.overThreshold, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	expected = `
+# HELP haproxy_stick_table_over_threshold_key Value of the entries above the configured threshold, limited to the highest ones
+# TYPE haproxy_stick_table_over_threshold_key gauge
+haproxy_stick_table_over_threshold_key{client_ip="3.3.3.3",name="table_requests_limiter_src_ip"} 500
+haproxy_stick_table_over_threshold_key{client_ip="4.4.4.4",name="table_requests_limiter_src_ip"} 300
+`
+	if err := testutil.CollectAndCompare(e.overThresholdKeys, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,128 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// BackendNDJSON writes every entry of the tables as a JSON object per line, for log pipelines
+const BackendNDJSON = "ndjson"
+
+// NDJSONConfig appends the parsed entries of every run to a file or stdout as newline delimited
+// JSON. An entry is written like in the JSON output of the query command, with the time its table
+// was queried, the instance, the table and its type:
+//
+//	{"time":"2024-05-02T10:00:00Z","instance":"lb1","table":"t","type":"ip","key":"1.2.3.4","use":0,"exp":1000,"shard":0,"http_req_rate":5}
+type NDJSONConfig struct {
+	// Path is the file the entries are appended to, "-" for stdout
+	Path string
+	// Instance tells apart the HAProxy of the records, usually the host name
+	Instance string
+	// MaxSize rotates the file before it grows over this number of bytes, zero to never rotate
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept as Path.1, Path.2 and so on, the oldest
+	// being removed
+	MaxBackups int
+}
+
+// Write appends the entries of the tables
+func (c NDJSONConfig) Write(ctx context.Context, e *StickTableExporter) error {
+	var buf bytes.Buffer
+	if err := c.encode(&buf, e); err != nil {
+		return err
+	}
+	if c.Path == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	if err := c.rotate(int64(buf.Len())); err != nil {
+		return fmt.Errorf("Failed to rotate %s: %v", c.Path, err)
+	}
+	f, err := os.OpenFile(c.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to open %s: %v", c.Path, err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to write entries to %s: %v", c.Path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Failed to write entries to %s: %v", c.Path, err)
+	}
+	e.logger.Debug("Wrote entries", "file", c.Path, "bytes", buf.Len())
+
+	return nil
+}
+
+// encode writes a JSON object per entry, the tables sorted by name
+func (c NDJSONConfig) encode(w io.Writer, e *StickTableExporter) error {
+	names := make([]string, 0, len(e.tables))
+	for name := range e.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	enc := json.NewEncoder(w)
+	for _, name := range names {
+		t := e.tables[name]
+		at := e.queriedAt[name].UTC().Format(time.RFC3339Nano)
+		for _, entry := range t.entries {
+			o := map[string]any{
+				"time":     at,
+				"instance": c.Instance,
+				"table":    name,
+				"type":     t.header.Type,
+				"key":      e.anonymizer.EntryKey(entry.Key),
+				"use":      entry.Use,
+				"exp":      entry.Exp,
+				"shard":    entry.Shard,
+			}
+			for _, d := range entry.Data {
+				o[d.Name] = d.Value
+			}
+			if err := enc.Encode(o); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rotate moves Path to Path.1, after shifting the previous backups, when writing size more
+// bytes would make it grow over MaxSize
+func (c NDJSONConfig) rotate(size int64) error {
+	if c.MaxSize <= 0 {
+		return nil
+	}
+	info, err := os.Stat(c.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 || info.Size()+size <= c.MaxSize {
+		return nil
+	}
+
+	if c.MaxBackups <= 0 {
+		return os.Remove(c.Path)
+	}
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", c.Path, i) }
+	if err := os.Remove(backup(c.MaxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := c.MaxBackups - 1; i > 0; i-- {
+		if err := os.Rename(backup(i), backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(c.Path, backup(1))
+}
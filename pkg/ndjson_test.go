@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// readNDJSON returns the objects of an NDJSON file, without their time
+func readNDJSON(t *testing.T, filename string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", filename, err)
+	}
+	defer f.Close()
+
+	var objects []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var o map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &o); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		if o["time"] == "" {
+			t.Errorf("record without time: %v", o)
+		}
+		delete(o, "time")
+		objects = append(objects, o)
+	}
+	return objects
+}
+
+func Test_NDJSONConfigWrite(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "entries.ndjson")
+	cfg := NDJSONConfig{Path: path, Instance: "lb1"}
+	if err := cfg.Write(context.Background(), testCollect(t)); err != nil {
+		t.Fatalf("Write() errored: %v", err)
+	}
+
+	// All the data types of the entries are written, not only the exported one
+	record := func(key string, exp float64, connCnt float64, rate float64) map[string]any {
+		return map[string]any{
+			"instance": "lb1", "table": "table_requests_limiter_src_ip", "type": "ip",
+			"key": key, "use": 0.0, "exp": exp, "shard": 0.0, "conn_cnt": connCnt, "http_req_rate": rate,
+		}
+	}
+	expected := []map[string]any{
+		record("1.32.20.122", 26834, 3, 1),
+		record("1.39.115.67", 44496, 9, 2321),
+		record("2001:db8::1", 44496, 12, 150),
+	}
+	if diff := cmp.Diff(expected, readNDJSON(t, path)); diff != "" {
+		t.Errorf("records mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_NDJSONConfigRotate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name            string
+		maxSize         int64
+		maxBackups      int
+		runs            int
+		expectedRecords []int
+	}{
+		{name: "no rotation", maxSize: 0, maxBackups: 2, runs: 3, expectedRecords: []int{9}},
+		{name: "rotation", maxSize: 1, maxBackups: 2, runs: 4, expectedRecords: []int{3, 3, 3}},
+		{name: "no backups", maxSize: 1, maxBackups: 0, runs: 2, expectedRecords: []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "entries.ndjson")
+			cfg := NDJSONConfig{Path: path, MaxSize: tt.maxSize, MaxBackups: tt.maxBackups}
+			e := testCollect(t)
+			for i := 0; i < tt.runs; i++ {
+				if err := cfg.Write(context.Background(), e); err != nil {
+					t.Fatalf("Write() errored: %v", err)
+				}
+			}
+
+			var records []int
+			for i, name := range []string{path, path + ".1", path + ".2", path + ".3"} {
+				if _, err := os.Stat(name); err != nil {
+					if i == 0 || !os.IsNotExist(err) {
+						t.Fatalf("Failed to stat %s: %v", name, err)
+					}
+					break
+				}
+				records = append(records, len(readNDJSON(t, name)))
+			}
+			if diff := cmp.Diff(tt.expectedRecords, records); diff != "" {
+				t.Errorf("records per file mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
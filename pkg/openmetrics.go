@@ -0,0 +1,126 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Formats of the file the metrics are written to
+const (
+	OutputText        = "text"
+	OutputOpenMetrics = "openmetrics"
+)
+
+// metricUnits are the units of the metrics which have one, OpenMetrics requires
+// their name to end with it.
+var metricUnits = []string{"seconds"}
+
+// unitGatherer sets the unit of the gathered metrics, client_golang doesn't support it
+type unitGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g unitGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, mf := range families {
+		for _, unit := range metricUnits {
+			if strings.HasSuffix(mf.GetName(), "_"+unit) {
+				mf.Unit = &unit
+				break
+			}
+		}
+	}
+	return families, err
+}
+
+// metricValue returns the value of a gathered gauge or counter
+func metricValue(m *dto.Metric) float64 {
+	if m.Counter != nil {
+		return m.GetCounter().GetValue()
+	}
+	return m.GetGauge().GetValue()
+}
+
+// timestampCollector attaches the time a table was queried to the samples of the table
+type timestampCollector struct {
+	prometheus.Collector
+	// queriedAt holds the time every table was queried, indexed by name
+	queriedAt map[string]time.Time
+	// tableLabel is the name of the label holding the name of the table
+	tableLabel string
+}
+
+func (c timestampCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collector.Collect(metrics)
+		close(metrics)
+	}()
+	for m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err == nil {
+			for _, l := range pb.GetLabel() {
+				if l.GetName() != c.tableLabel {
+					continue
+				}
+				if t, ok := c.queriedAt[l.GetValue()]; ok {
+					m = prometheus.NewMetricWithTimestamp(t, m)
+				}
+				break
+			}
+		}
+		ch <- m
+	}
+}
+
+// writeOpenMetrics writes the metrics to a file in OpenMetrics text format. Like
+// prometheus.WriteToTextfile, it writes to a temporary file renamed once complete.
+func writeOpenMetrics(filename string, g prometheus.Gatherer) error {
+	tmp := filename + "." + strconv.Itoa(os.Getpid())
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := encodeOpenMetrics(f, g); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// encodeOpenMetrics writes the metrics in OpenMetrics text format with their unit, created
+// lines and the final # EOF.
+func encodeOpenMetrics(w io.Writer, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeOpenMetrics), expfmt.WithCreatedLines(), expfmt.WithUnit())
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return fmt.Errorf("Failed to encode %s: %v", mf.GetName(), err)
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
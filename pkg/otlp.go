@@ -0,0 +1,168 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// BackendOTLP sends the metrics to an OpenTelemetry collector
+const BackendOTLP = "otlp"
+
+// Protocols of the OTLP backend
+const (
+	OTLPGRPC = "grpc"
+	OTLPHTTP = "http"
+)
+
+// OTLPConfig sends the metrics as OpenTelemetry gauges, named and attributed like the Prometheus
+// metrics, with the time every table was queried.
+type OTLPConfig struct {
+	// Endpoint is the host:port of the collector
+	Endpoint string
+	// Protocol is OTLPGRPC or OTLPHTTP, grpc when empty
+	Protocol string
+	// Insecure disables TLS
+	Insecure bool
+	// Headers are sent with every export, e.g. for authentication
+	Headers map[string]string
+	// Host is the host.name resource attribute
+	Host string
+	// Instance is the haproxy.instance resource attribute, telling apart the HAProxy of a host
+	Instance string
+	// Timeout bounds every export, zero for the default of the exporter
+	Timeout time.Duration
+}
+
+// otlpOutput exports the metrics over an exporter created once, so a daemon keeps its
+// connection to the collector between runs
+type otlpOutput struct {
+	config   OTLPConfig
+	exporter sdkmetric.Exporter
+}
+
+// newOTLPOutput returns an output exporting to the collector of cfg, Close shuts it down
+func newOTLPOutput(cfg OTLPConfig) (*otlpOutput, error) {
+	exporter, err := cfg.exporter(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create OTLP exporter: %v", err)
+	}
+	return &otlpOutput{config: cfg, exporter: exporter}, nil
+}
+
+// Write exports the metrics of the tables to the collector
+func (o *otlpOutput) Write(ctx context.Context, e *StickTableExporter) error {
+	rm, err := o.config.resourceMetrics(e, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := o.exporter.Export(ctx, rm); err != nil {
+		return fmt.Errorf("Failed to export metrics to %s: %v", o.config.Endpoint, err)
+	}
+	e.logger.Debug("Exported metrics with OTLP", "endpoint", o.config.Endpoint, "protocol", o.config.Protocol, "metrics", len(rm.ScopeMetrics[0].Metrics))
+
+	return nil
+}
+
+// Close shuts the exporter down, closing its connection
+func (o *otlpOutput) Close() error {
+	return o.exporter.Shutdown(context.Background())
+}
+
+// exporter returns the exporter of the protocol
+func (c OTLPConfig) exporter(ctx context.Context) (sdkmetric.Exporter, error) {
+	switch c.Protocol {
+	case OTLPGRPC, "":
+		opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(c.Endpoint)}
+		if c.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		if len(c.Headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(c.Headers))
+		}
+		if c.Timeout > 0 {
+			opts = append(opts, otlpmetricgrpc.WithTimeout(c.Timeout))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	case OTLPHTTP:
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(c.Endpoint)}
+		if c.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		if len(c.Headers) > 0 {
+			opts = append(opts, otlpmetrichttp.WithHeaders(c.Headers))
+		}
+		if c.Timeout > 0 {
+			opts = append(opts, otlpmetrichttp.WithTimeout(c.Timeout))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("Unsupported OTLP protocol '%s'", c.Protocol)
+	}
+}
+
+// resourceMetrics converts the Prometheus metrics to OpenTelemetry gauges and monotonic sums, the labels become
+// attributes and the samples are timed when their table was queried, or now.
+func (c OTLPConfig) resourceMetrics(e *StickTableExporter, now time.Time) (*metricdata.ResourceMetrics, error) {
+	families, err := e.Gatherer().Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	tableLabel := e.relabel.labelName("name")
+	metrics := make([]metricdata.Metrics, 0, len(families))
+	for _, mf := range families {
+		points := make([]metricdata.DataPoint[float64], 0, len(mf.GetMetric()))
+		for _, m := range mf.GetMetric() {
+			attrs := make([]attribute.KeyValue, 0, len(m.GetLabel()))
+			at := now
+			for _, l := range m.GetLabel() {
+				attrs = append(attrs, attribute.String(l.GetName(), l.GetValue()))
+				if t, ok := e.queriedAt[l.GetValue()]; ok && l.GetName() == tableLabel {
+					at = t
+				}
+			}
+			points = append(points, metricdata.DataPoint[float64]{
+				Attributes: attribute.NewSet(attrs...),
+				Time:       at,
+				Value:      metricValue(m),
+			})
+		}
+		var data metricdata.Aggregation = metricdata.Gauge[float64]{DataPoints: points}
+		if mf.GetType() == dto.MetricType_COUNTER {
+			data = metricdata.Sum[float64]{DataPoints: points, Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+		}
+		metrics = append(metrics, metricdata.Metrics{
+			Name:        mf.GetName(),
+			Description: mf.GetHelp(),
+			Unit:        otlpUnits[mf.GetUnit()],
+			Data:        data,
+		})
+	}
+
+	return &metricdata.ResourceMetrics{
+		Resource: resource.NewSchemaless(
+			attribute.String("service.name", "haproxy-table-exporter"),
+			attribute.String("host.name", c.Host),
+			attribute.String("haproxy.instance", c.Instance),
+		),
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Scope:   instrumentation.Scope{Name: "haproxy-table-exporter"},
+			Metrics: metrics,
+		}},
+	}, nil
+}
+
+// otlpUnits maps the Prometheus units to the UCUM units of OpenTelemetry
+var otlpUnits = map[string]string{
+	"seconds": "s",
+}
@@ -0,0 +1,145 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// testOTLPReceiver records the export requests received over gRPC or HTTP
+type testOTLPReceiver struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	mu       sync.Mutex
+	requests []*colmetricspb.ExportMetricsServiceRequest
+}
+
+func (r *testOTLPReceiver) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *testOTLPReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil || req.URL.Path != "/v1/metrics" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var export colmetricspb.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(body, &export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response, _ := r.Export(req.Context(), &export)
+	b, _ := proto.Marshal(response)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(b)
+}
+
+// startTestOTLPReceiver starts a receiver of the protocol and returns its endpoint
+func startTestOTLPReceiver(t *testing.T, protocol string) (*testOTLPReceiver, string) {
+	t.Helper()
+	receiver := &testOTLPReceiver{}
+	if protocol == OTLPHTTP {
+		server := httptest.NewServer(receiver)
+		t.Cleanup(server.Close)
+		return receiver, strings.TrimPrefix(server.URL, "http://")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(server, receiver)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return receiver, listener.Addr().String()
+}
+
+func Test_OTLPOutput(t *testing.T) {
+	t.Parallel()
+	for _, protocol := range []string{OTLPGRPC, OTLPHTTP} {
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			receiver, endpoint := startTestOTLPReceiver(t, protocol)
+			output, err := newOTLPOutput(OTLPConfig{Endpoint: endpoint, Protocol: protocol, Insecure: true, Host: "lb1", Instance: "/run/haproxy.sock", Timeout: 5 * time.Second})
+			if err != nil {
+				t.Fatalf("newOTLPOutput() errored: %v", err)
+			}
+			// The exporter is reused by every write until the output is closed
+			for i := 0; i < 2; i++ {
+				if err := output.Write(context.Background(), testCollect(t)); err != nil {
+					t.Fatalf("Write() errored: %v", err)
+				}
+			}
+			if err := output.Close(); err != nil {
+				t.Fatalf("Close() errored: %v", err)
+			}
+			if err := output.Write(context.Background(), testCollect(t)); err == nil {
+				t.Error("Write() after Close() didn't error")
+			}
+
+			receiver.mu.Lock()
+			defer receiver.mu.Unlock()
+			if len(receiver.requests) != 2 {
+				t.Fatalf("received %d requests, want 2", len(receiver.requests))
+			}
+			rm := receiver.requests[0].GetResourceMetrics()[0]
+			resource := make(map[string]string)
+			for _, kv := range rm.GetResource().GetAttributes() {
+				resource[kv.GetKey()] = kv.GetValue().GetStringValue()
+			}
+			expectedResource := map[string]string{"service.name": "haproxy-table-exporter", "host.name": "lb1", "haproxy.instance": "/run/haproxy.sock"}
+			if diff := cmp.Diff(expectedResource, resource); diff != "" {
+				t.Errorf("resource mismatch (-want +got):\n%s", diff)
+			}
+
+			var entries []string
+			units := make(map[string]string)
+			for _, m := range rm.GetScopeMetrics()[0].GetMetrics() {
+				units[m.GetName()] = m.GetUnit()
+				if m.GetName() != "haproxy_stick_table" {
+					continue
+				}
+				for _, dp := range m.GetGauge().GetDataPoints() {
+					if dp.GetTimeUnixNano() == 0 {
+						t.Errorf("data point without time: %v", dp)
+					}
+					var attrs []string
+					for _, kv := range dp.GetAttributes() {
+						attrs = append(attrs, kv.GetKey()+"="+kv.GetValue().GetStringValue())
+					}
+					sort.Strings(attrs)
+					entries = append(entries, strings.Join(attrs, ",")+" "+strconv.FormatFloat(dp.GetAsDouble(), 'g', -1, 64))
+				}
+			}
+			sort.Strings(entries)
+			expected := []string{
+				"client_ip=1.32.20.122,data_type=http_req_rate,name=table_requests_limiter_src_ip,type=ip 1",
+				"client_ip=1.39.115.67,data_type=http_req_rate,name=table_requests_limiter_src_ip,type=ip 2321",
+				"client_ip=2001:db8::1,data_type=http_req_rate,name=table_requests_limiter_src_ip,type=ip 150",
+			}
+			if diff := cmp.Diff(expected, entries); diff != "" {
+				t.Errorf("entries mismatch (-want +got):\n%s", diff)
+			}
+			if unit := units["haproxy_stick_table_query_duration_seconds"]; unit != "s" {
+				t.Errorf("unit of the query duration = %q, want s", unit)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Output delivers the metrics of a run, e.g. to a file, a Pushgateway or a StatsD server.
+// The tables are queried and parsed once and the same exporter is given to every output.
+type Output interface {
+	Write(ctx context.Context, e *StickTableExporter) error
+}
+
+// NewOutput returns the output of a backend, configured from cfg. The output may keep state
+// between runs, e.g. the events one, so it is created once and reused by a daemon.
+func NewOutput(backend string, cfg Config) (Output, error) {
+	switch backend {
+	case BackendTextfile, "":
+		return textfileOutput{filename: cfg.PrometheusFile, format: cfg.OutputFormat}, nil
+	case BackendPushgateway:
+		return pushgatewayOutput(cfg.Push), nil
+	case BackendRemoteWrite:
+		return remoteWriteOutput(cfg.Push), nil
+	case BackendStatsD:
+		return cfg.StatsD, nil
+	case BackendOTLP:
+		return newOTLPOutput(cfg.OTLP)
+	case BackendNDJSON:
+		return cfg.NDJSON, nil
+	case BackendEvents:
+		return newEventsOutput(cfg.Events)
+	case BackendHistory:
+		return cfg.History, nil
+	default:
+		return nil, fmt.Errorf("Unsupported backend '%s'", backend)
+	}
+}
+
+// textfileOutput writes the metrics to a file for the node_exporter textfile collector
+type textfileOutput struct {
+	filename string
+	format   string
+}
+
+func (o textfileOutput) Write(ctx context.Context, e *StickTableExporter) error {
+	if err := e.WriteMetrics(o.filename, o.format); err != nil {
+		return fmt.Errorf("Error writing metrics to file: %v", err)
+	}
+	return nil
+}
+
+// pushgatewayOutput pushes the metrics to a Pushgateway
+type pushgatewayOutput PushConfig
+
+func (o pushgatewayOutput) Write(ctx context.Context, e *StickTableExporter) error {
+	return e.Push(ctx, PushConfig(o))
+}
+
+// remoteWriteOutput sends the metrics to a remote-write endpoint
+type remoteWriteOutput PushConfig
+
+func (o remoteWriteOutput) Write(ctx context.Context, e *StickTableExporter) error {
+	return e.RemoteWrite(ctx, PushConfig(o))
+}
+
+// newOutputs returns the outputs of the backends of cfg, textfile when there is none
+func newOutputs(cfg Config) ([]Output, error) {
+	backends := cfg.Backends
+	if len(backends) == 0 {
+		backends = []string{BackendTextfile}
+	}
+	outputs := make([]Output, 0, len(backends))
+	for _, backend := range backends {
+		output, err := NewOutput(backend, cfg)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// closeOutputs releases the outputs holding a connection, e.g. the otlp one
+func closeOutputs(outputs []Output) error {
+	var errs []error
+	for _, output := range outputs {
+		if c, ok := output.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,166 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHealthCheckInterval is how long a session can stay idle before it is checked,
+// HAProxy closes idle sessions after its "stats timeout", 10s by default.
+const DefaultHealthCheckInterval = 5 * time.Second
+
+// Pool keeps sessions in interactive mode open, so commands don't pay for a new
+// connection each time. Sessions idle for longer than HealthCheckInterval are checked
+// before being reused, and a command failing on a reused session is retried once on
+// a new one, e.g. when HAProxy closed it during a reload.
+type Pool struct {
+	client *Client
+	// idle holds the sessions waiting for a command
+	idle chan *pooledSession
+	// slots limits the number of open sessions
+	slots chan struct{}
+	// HealthCheckInterval is how long a session can stay idle before it is checked
+	HealthCheckInterval time.Duration
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// pooledSession is a session and the time it was last used
+type pooledSession struct {
+	*Session
+	lastUsed time.Time
+}
+
+// NewPool returns a pool of at most size sessions opened with the client
+func NewPool(client *Client, size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{
+		client:              client,
+		idle:                make(chan *pooledSession, size),
+		slots:               make(chan struct{}, size),
+		HealthCheckInterval: DefaultHealthCheckInterval,
+	}
+}
+
+// get returns an idle session, or a new one when the pool isn't full, and whether it was reused
+func (p *Pool) get(ctx context.Context) (*pooledSession, bool, error) {
+	for {
+		var s *pooledSession
+		select {
+		case s = <-p.idle:
+		default:
+			select {
+			case s = <-p.idle:
+			case p.slots <- struct{}{}:
+				session, err := p.client.Interactive(ctx)
+				if err != nil {
+					<-p.slots
+					return nil, false, err
+				}
+				return &pooledSession{Session: session}, false, nil
+			case <-ctx.Done():
+				return nil, false, ctx.Err()
+			}
+		}
+
+		if time.Since(s.lastUsed) < p.HealthCheckInterval {
+			return s, true, nil
+		}
+		if err := s.ping(ctx); err != nil {
+			p.client.logger().Debug("Discarding unhealthy session", "error", err)
+			p.discard(s)
+			continue
+		}
+		return s, true, nil
+	}
+}
+
+// put returns a session to the pool, it is closed when the pool is closed
+func (p *Pool) put(s *pooledSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		p.discard(s)
+		return
+	}
+	s.lastUsed = time.Now()
+	p.idle <- s
+}
+
+// discard closes a session and frees its slot
+func (p *Pool) discard(s *pooledSession) {
+	s.Close()
+	<-p.slots
+}
+
+// Exec sends a command on a session of the pool and returns the response without the
+// prompt and the trailing empty line.
+func (p *Pool) Exec(ctx context.Context, cmd string) (string, error) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return "", fmt.Errorf("Pool is closed")
+	}
+
+	for attempt := 0; ; attempt++ {
+		s, reused, err := p.get(ctx)
+		if err != nil {
+			return "", err
+		}
+		response, err := s.Exec(ctx, cmd)
+		if err == nil {
+			p.put(s)
+			return response, nil
+		}
+		p.discard(s)
+		if !reused || attempt > 0 || ctx.Err() != nil {
+			return "", err
+		}
+		p.client.logger().Debug("Reconnecting after a failure on a reused session", "error", err)
+	}
+}
+
+// Close closes the idle sessions, the sessions in use are closed when they are returned
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for {
+		select {
+		case s := <-p.idle:
+			p.discard(s)
+		default:
+			return nil
+		}
+	}
+}
+
+// ping checks that the session is still open, HAProxy answers an empty line with the prompt
+func (s *Session) ping(ctx context.Context) error {
+	defer interruptOnCancel(ctx, s.conn)()
+
+	if err := s.client.write(ctx, s.conn, ""); err != nil {
+		return err
+	}
+	var data strings.Builder
+	for !strings.HasSuffix(data.String(), "> ") {
+		n, err := s.client.read(ctx, s.conn, s.buf)
+		if err == io.EOF {
+			return fmt.Errorf("connection closed by HAProxy")
+		}
+		if err != nil {
+			return err
+		}
+		data.Write(s.buf[0:n])
+	}
+
+	return nil
+}
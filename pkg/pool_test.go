@@ -0,0 +1,106 @@
+package exporter
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Pool(t *testing.T) {
+	t.Parallel()
+	socket := startTestServer(t, testResponses)
+	ctx := context.Background()
+
+	tests := []struct {
+		name                string
+		healthCheckInterval time.Duration
+	}{
+		// The session closed by HAProxy fails the command which is retried on a new session
+		{name: "reconnect", healthCheckInterval: time.Hour},
+		// The session closed by HAProxy fails the health check and is replaced before the command
+		{name: "health check", healthCheckInterval: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p := NewPool(NewClient(socket, Timeouts{}, testLogger), 1)
+			p.HealthCheckInterval = tt.healthCheckInterval
+			defer p.Close()
+
+			if _, err := p.Exec(ctx, "show info"); err != nil {
+				t.Fatalf("Exec() errored: %v", err)
+			}
+			// Make the server close the idle session
+			s := <-p.idle
+			s.conn.Write([]byte("quit\n"))
+			p.idle <- s
+			time.Sleep(10 * time.Millisecond)
+
+			response, err := p.Exec(ctx, "show info")
+			if err != nil {
+				t.Fatalf("Exec() errored: %v", err)
+			}
+			if !strings.HasPrefix(response, "Name: HAProxy\n") {
+				t.Errorf("Exec() = %q", response)
+			}
+			if s2 := <-p.idle; s2 == s {
+				t.Error("Exec() reused the closed session")
+			} else {
+				p.idle <- s2
+			}
+		})
+	}
+}
+
+func Test_PoolConcurrent(t *testing.T) {
+	t.Parallel()
+	socket := startTestServer(t, testResponses)
+	client := NewClient(socket, Timeouts{Overall: 1 * time.Second}, testLogger)
+	client.Persistent(2)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := client.ShowInfo(context.Background()); err != nil {
+					t.Errorf("ShowInfo() errored: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(client.pool.slots); n > 2 {
+		t.Errorf("Pool opened %d sessions, want at most 2", n)
+	}
+}
+
+func benchmarkClientExec(b *testing.B, persistent bool) {
+	socket := startTestServer(b, testResponses)
+	client := NewClient(socket, Timeouts{Overall: 1 * time.Second}, testLogger)
+	if persistent {
+		client.Persistent(1)
+		defer client.Close()
+	}
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Exec(ctx, "show table table_requests_limiter_src_ip"); err != nil {
+			b.Fatalf("Exec() errored: %v", err)
+		}
+	}
+}
+
+func BenchmarkClientExecOneShot(b *testing.B) {
+	benchmarkClientExec(b, false)
+}
+
+func BenchmarkClientExecPersistent(b *testing.B) {
+	benchmarkClientExec(b, true)
+}
@@ -0,0 +1,203 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Backends the metrics are delivered with
+const (
+	// BackendTextfile writes the metrics to Config.PrometheusFile for the node_exporter textfile collector
+	BackendTextfile = "textfile"
+	// BackendPushgateway pushes the metrics of every table to a Pushgateway group of its own
+	BackendPushgateway = "pushgateway"
+	// BackendRemoteWrite sends the metrics to a Prometheus remote-write endpoint
+	BackendRemoteWrite = "remote-write"
+)
+
+// DefaultPushJob is the job label of the pushed metrics when PushConfig.Job is empty
+const DefaultPushJob = "haproxy_stick_table"
+
+// PushConfig holds where and how the metrics are pushed by the pushgateway and remote-write backends
+type PushConfig struct {
+	// URL is the address of the Pushgateway, or the full URL of the remote-write endpoint
+	URL string
+	// Job is the job label of the metrics, DefaultPushJob when empty
+	Job string
+	// Instance is the instance label of the metrics, usually the host name of the HAProxy box
+	Instance string
+	// Timeout bounds every push, zero for no limit
+	Timeout time.Duration
+}
+
+func (p PushConfig) job() string {
+	if p.Job == "" {
+		return DefaultPushJob
+	}
+	return p.Job
+}
+
+// Push pushes the metrics of every table to the Pushgateway, grouped by instance and table so
+// the metrics of a table replace only the previous ones of the same table and instance.
+func (e *StickTableExporter) Push(ctx context.Context, cfg PushConfig) error {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	names := make([]string, 0, len(e.queriedAt))
+	for name := range e.queriedAt {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pusher := push.New(cfg.URL, cfg.job()).
+			Gatherer(tableGatherer{Gatherer: e.Gatherer(), label: e.relabel.labelName("name"), table: name}).
+			Grouping("table", name)
+		if cfg.Instance != "" {
+			pusher = pusher.Grouping("instance", cfg.Instance)
+		}
+		if err := pusher.PushContext(ctx); err != nil {
+			return fmt.Errorf("Failed to push metrics of %s to %s: %v", name, cfg.URL, err)
+		}
+	}
+	e.logger.Debug("Pushed metrics", "url", cfg.URL, "tables", len(names))
+
+	return nil
+}
+
+// tableGatherer gathers the metrics of a single table, identified by their name label
+type tableGatherer struct {
+	prometheus.Gatherer
+	// label is the name of the label holding the name of the table
+	label string
+	table string
+}
+
+func (g tableGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	var filtered []*dto.MetricFamily
+	for _, mf := range families {
+		var metrics []*dto.Metric
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == g.label && l.GetValue() == g.table {
+					metrics = append(metrics, m)
+					break
+				}
+			}
+		}
+		if len(metrics) > 0 {
+			mf.Metric = metrics
+			filtered = append(filtered, mf)
+		}
+	}
+	return filtered, err
+}
+
+// RemoteWrite sends the metrics to a Prometheus remote-write endpoint, as a snappy compressed
+// protobuf WriteRequest. Samples without a timestamp are sent with the current time.
+func (e *StickTableExporter) RemoteWrite(ctx context.Context, cfg PushConfig) error {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	families, err := e.Gatherer().Gather()
+	if err != nil {
+		return err
+	}
+	extraLabels := map[string]string{"job": cfg.job()}
+	if cfg.Instance != "" {
+		extraLabels["instance"] = cfg.Instance
+	}
+	body := encodeWriteRequest(families, extraLabels, time.Now())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		return fmt.Errorf("Failed to create remote-write request: %v", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to send metrics to %s: %v", cfg.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Remote-write endpoint %s answered %s: %s", cfg.URL, resp.Status, bytes.TrimSpace(message))
+	}
+	e.logger.Debug("Sent metrics with remote-write", "url", cfg.URL, "families", len(families))
+
+	return nil
+}
+
+// encodeWriteRequest encodes the gauges and counters of the families as a remote-write WriteRequest:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+//
+// The labels of a series are sorted by name as the endpoints require.
+func encodeWriteRequest(families []*dto.MetricFamily, extraLabels map[string]string, now time.Time) []byte {
+	var req []byte
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{"__name__": mf.GetName()}
+			for name, value := range extraLabels {
+				labels[name] = value
+			}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			names := make([]string, 0, len(labels))
+			for name := range labels {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			var series []byte
+			for _, name := range names {
+				var label []byte
+				label = protowire.AppendTag(label, 1, protowire.BytesType)
+				label = protowire.AppendString(label, name)
+				label = protowire.AppendTag(label, 2, protowire.BytesType)
+				label = protowire.AppendString(label, labels[name])
+				series = protowire.AppendTag(series, 1, protowire.BytesType)
+				series = protowire.AppendBytes(series, label)
+			}
+			timestamp := now.UnixMilli()
+			if m.TimestampMs != nil {
+				timestamp = m.GetTimestampMs()
+			}
+			var sample []byte
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(metricValue(m)))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(timestamp))
+			series = protowire.AppendTag(series, 2, protowire.BytesType)
+			series = protowire.AppendBytes(series, sample)
+
+			req = protowire.AppendTag(req, 1, protowire.BytesType)
+			req = protowire.AppendBytes(req, series)
+		}
+	}
+	return req
+}
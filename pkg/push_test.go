@@ -0,0 +1,198 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testCollect returns the metrics of the saved response of table_requests_limiter_src_ip
+func testCollect(t *testing.T) *StickTableExporter {
+	t.Helper()
+	e, err := Collect(context.Background(), Config{
+		Tables:   []Table{{Name: "table_requests_limiter_src_ip", DataType: "http_req_rate"}},
+		FromFile: filepath.Join("testdata", "table_requests_limiter_src_ip.dump"),
+		Logger:   testLogger,
+	})
+	if err != nil {
+		t.Fatalf("Collect() errored: %v", err)
+	}
+	return e
+}
+
+// receivedRequest is a request recorded by a test receiver
+type receivedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// startTestReceiver starts an HTTP server recording the requests and answering them with status
+func startTestReceiver(t *testing.T, status int) (*httptest.Server, func() []receivedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []receivedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, receivedRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: body})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []receivedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func Test_Push(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name             string
+		status           int
+		wantErr          bool
+		expectedGrouping map[string]string
+	}{
+		{
+			name:   "pushed",
+			status: http.StatusOK,
+			expectedGrouping: map[string]string{
+				"job":      "haproxy_stick_table",
+				"instance": "lb1",
+				"table":    "table_requests_limiter_src_ip",
+			},
+		},
+		{name: "rejected", status: http.StatusBadRequest, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server, requests := startTestReceiver(t, tt.status)
+			err := testCollect(t).Push(context.Background(), PushConfig{URL: server.URL, Instance: "lb1", Timeout: 5 * time.Second})
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("errored = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := requests()
+			if len(got) != 1 {
+				t.Fatalf("received %d requests, want 1", len(got))
+			}
+			if got[0].method != http.MethodPut {
+				t.Errorf("method = %s, want PUT", got[0].method)
+			}
+			components := strings.Split(strings.TrimPrefix(got[0].path, "/metrics/"), "/")
+			grouping := make(map[string]string)
+			for i := 0; i+1 < len(components); i += 2 {
+				grouping[components[i]] = components[i+1]
+			}
+			if diff := cmp.Diff(tt.expectedGrouping, grouping); diff != "" {
+				t.Errorf("grouping mismatch (-want +got):\n%s", diff)
+			}
+			if len(got[0].body) == 0 {
+				t.Error("pushed an empty body")
+			}
+		})
+	}
+}
+
+// decodeWriteRequest decodes the series of a remote-write request as name{labels} and their value
+func decodeWriteRequest(t *testing.T, body []byte) map[string]float64 {
+	t.Helper()
+	// fields returns the values of the length-delimited and fixed64 fields of a message
+	fields := func(b []byte) map[protowire.Number][][]byte {
+		values := make(map[protowire.Number][][]byte)
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatalf("Malformed message: %v", protowire.ParseError(n))
+			}
+			b = b[n:]
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				t.Fatalf("Malformed message: %v", protowire.ParseError(n))
+			}
+			switch typ {
+			case protowire.BytesType:
+				v, _ := protowire.ConsumeBytes(b)
+				values[num] = append(values[num], v)
+			case protowire.Fixed64Type:
+				values[num] = append(values[num], b[:8])
+			}
+			b = b[n:]
+		}
+		return values
+	}
+
+	series := make(map[string]float64)
+	for _, ts := range fields(body)[1] {
+		f := fields(ts)
+		var name string
+		var labels []string
+		for _, l := range f[1] {
+			lf := fields(l)
+			if string(lf[1][0]) == "__name__" {
+				name = string(lf[2][0])
+				continue
+			}
+			labels = append(labels, string(lf[1][0])+"="+string(lf[2][0]))
+		}
+		if !sort.StringsAreSorted(labels) {
+			t.Errorf("labels of %s aren't sorted: %v", name, labels)
+		}
+		value, _ := protowire.ConsumeFixed64(fields(f[2][0])[1][0])
+		series[name+"{"+strings.Join(labels, ",")+"}"] = math.Float64frombits(value)
+	}
+	return series
+}
+
+func Test_RemoteWrite(t *testing.T) {
+	t.Parallel()
+	server, requests := startTestReceiver(t, http.StatusNoContent)
+	if err := testCollect(t).RemoteWrite(context.Background(), PushConfig{URL: server.URL + "/api/v1/write", Instance: "lb1"}); err != nil {
+		t.Fatalf("RemoteWrite() errored: %v", err)
+	}
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("received %d requests, want 1", len(got))
+	}
+	if got[0].path != "/api/v1/write" || got[0].header.Get("Content-Encoding") != "snappy" || got[0].header.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("request = %s %v", got[0].path, got[0].header)
+	}
+	body, err := snappy.Decode(nil, got[0].body)
+	if err != nil {
+		t.Fatalf("Failed to decompress request: %v", err)
+	}
+	series := decodeWriteRequest(t, body)
+	expected := map[string]float64{
+		`haproxy_stick_table{client_ip=1.32.20.122,data_type=http_req_rate,instance=lb1,job=haproxy_stick_table,name=table_requests_limiter_src_ip,type=ip}`:             1,
+		`haproxy_stick_table{client_ip=1.39.115.67,data_type=http_req_rate,instance=lb1,job=haproxy_stick_table,name=table_requests_limiter_src_ip,type=ip}`:             2321,
+		`haproxy_stick_table{client_ip=2001:db8::1,data_type=http_req_rate,instance=lb1,job=haproxy_stick_table,name=table_requests_limiter_src_ip,type=ip}`:             150,
+		`haproxy_stick_table_malformed_lines{instance=lb1,job=haproxy_stick_table,name=table_requests_limiter_src_ip}`:                                                   0,
+		`haproxy_stick_table_info{data_type=http_req_rate,instance=lb1,job=haproxy_stick_table,name=table_requests_limiter_src_ip,period_ms=60000,size=1048576,type=ip}`: 1,
+	}
+	for name, value := range expected {
+		if got, ok := series[name]; !ok || got != value {
+			t.Errorf("series %s = %v (found %v), want %v", name, got, ok, value)
+		}
+	}
+}
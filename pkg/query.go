@@ -0,0 +1,171 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats of WriteEntries
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+// QueryOptions selects the entries of a stick-table returned by Client.ShowTable
+type QueryOptions struct {
+	// Table is the name of the stick-table to query
+	Table string
+	// DataType and MinimumValue filter the entries on data.<DataType> <Operator> <MinimumValue>,
+	// no filter is applied when DataType is empty
+	DataType     string
+	MinimumValue int
+	// Operator is one of eq, ne, le, lt, ge or gt, gt when empty
+	Operator string
+	// Key looks up a single entry, the filter is ignored when it is set
+	Key string
+}
+
+// validate checks the arguments pasted in the command
+func (o QueryOptions) validate() error {
+	if err := CheckArgument("table", o.Table); err != nil {
+		return err
+	}
+	if o.DataType != "" {
+		if err := CheckArgument("data type", o.DataType); err != nil {
+			return err
+		}
+	}
+	if o.Key != "" {
+		if err := CheckArgument("key", o.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the "show table" command for the options
+func (o QueryOptions) command() string {
+	switch {
+	case o.Key != "":
+		return fmt.Sprintf("show table %s key %s", o.Table, o.Key)
+	case o.DataType != "":
+		operator := o.Operator
+		if operator == "" {
+			operator = "gt"
+		}
+		return fmt.Sprintf("show table %s data.%s %s %d", o.Table, o.DataType, operator, o.MinimumValue)
+	default:
+		return fmt.Sprintf("show table %s", o.Table)
+	}
+}
+
+// SortEntries sorts the entries by key, use, exp, shard or a data type. Keys are sorted
+// in ascending order and numbers in descending order, ties are broken by key. Addresses are
+// compared as addresses, IPv4 before IPv6, and come before the other keys sorted as strings.
+func SortEntries(entries []Entry, by string) {
+	value := func(e Entry) int {
+		switch by {
+		case "use":
+			return e.Use
+		case "exp":
+			return e.Exp
+		case "shard":
+			return e.Shard
+		}
+		v, _ := e.Value(by)
+		return v
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if by != "key" {
+			if vi, vj := value(entries[i]), value(entries[j]); vi != vj {
+				return vi > vj
+			}
+		}
+		return compareKeys(entries[i].Key, entries[j].Key) < 0
+	})
+}
+
+// compareKeys compares two keys as addresses when both are, as strings otherwise
+func compareKeys(a string, b string) int {
+	ipA, errA := netip.ParseAddr(a)
+	ipB, errB := netip.ParseAddr(b)
+	switch {
+	case errA == nil && errB == nil:
+		return ipA.Compare(ipB)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// dataColumns returns the data types found in the entries, in order of appearance
+func dataColumns(entries []Entry) []string {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		for _, d := range e.Data {
+			if !seen[d.Name] {
+				seen[d.Name] = true
+				columns = append(columns, d.Name)
+			}
+		}
+	}
+	return columns
+}
+
+// WriteEntries writes the entries as an aligned table, JSON or CSV
+func WriteEntries(w io.Writer, format string, entries []Entry) error {
+	columns := dataColumns(entries)
+	rows := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		row := []string{e.Key, strconv.Itoa(e.Use), strconv.Itoa(e.Exp), strconv.Itoa(e.Shard)}
+		for _, c := range columns {
+			v := ""
+			if value, ok := e.Value(c); ok {
+				v = strconv.Itoa(value)
+			}
+			row = append(row, v)
+		}
+		rows = append(rows, row)
+	}
+	header := append([]string{"key", "use", "exp", "shard"}, columns...)
+
+	switch format {
+	case FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(rows)
+		return cw.Error()
+	case FormatJSON:
+		objects := make([]map[string]any, 0, len(entries))
+		for _, e := range entries {
+			o := map[string]any{"key": e.Key, "use": e.Use, "exp": e.Exp, "shard": e.Shard}
+			for _, d := range e.Data {
+				o[d.Name] = d.Value
+			}
+			objects = append(objects, o)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(objects)
+	default:
+		return fmt.Errorf("Unsupported format '%s'", format)
+	}
+}
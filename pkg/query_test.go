@@ -0,0 +1,90 @@
+package exporter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_parseEntries(t *testing.T) {
+	t.Parallel()
+	input := "# table: be_app, type: string, size:1024, used:2\n" +
+		"0x7f6d48298b70: key=tenant-a use=1 exp=26834 gpc0=1 http_req_rate(10000)=12\n" +
+		"0x55e0d8f5cc20: key=tenant-b use=0 exp=44496 shard=2 gpc0=0 http_req_rate(10000)=3\n" +
+		"garbage"
+	expected := []Entry{
+		{Line: 2, Key: "tenant-a", Use: 1, Exp: 26834, Data: []DataValue{{Name: "gpc0", Value: 1}, {Name: "http_req_rate", Period: 10000, Value: 12}}},
+		{Line: 3, Key: "tenant-b", Exp: 44496, Shard: 2, Data: []DataValue{{Name: "gpc0", Value: 0}, {Name: "http_req_rate", Period: 10000, Value: 3}}},
+	}
+	entries, skipped, err := parseEntries(testLogger, input, false, nil)
+	if err != nil {
+		t.Fatalf("parseEntries() errored: %v", err)
+	}
+	if skipped != 1 {
+		t.Errorf("parseEntries() skipped %d lines, want 1", skipped)
+	}
+	if diff := cmp.Diff(expected, entries, cmpopts.IgnoreFields(Entry{}, "Raw")); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func Test_WriteEntries(t *testing.T) {
+	t.Parallel()
+	entries := []Entry{
+		{Key: "1.1.1.1", Exp: 100, Data: []DataValue{{Name: "http_req_rate", Period: 60000, Value: 5}}},
+		{Key: "2.2.2.2", Exp: 200, Data: []DataValue{{Name: "http_req_rate", Period: 60000, Value: 50}, {Name: "conn_cnt", Value: 1}}},
+		{Key: "0.0.0.1", Exp: 300, Data: []DataValue{{Name: "http_req_rate", Period: 60000, Value: 5}}},
+	}
+	SortEntries(entries, "http_req_rate")
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: FormatTable,
+			expected: "KEY      USE  EXP  SHARD  HTTP_REQ_RATE  CONN_CNT\n" +
+				"2.2.2.2  0    200  0      50             1\n" +
+				"0.0.0.1  0    300  0      5              \n" +
+				"1.1.1.1  0    100  0      5              \n",
+		},
+		{
+			format: FormatCSV,
+			expected: "key,use,exp,shard,http_req_rate,conn_cnt\n" +
+				"2.2.2.2,0,200,0,50,1\n" +
+				"0.0.0.1,0,300,0,5,\n" +
+				"1.1.1.1,0,100,0,5,\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteEntries(&buf, tt.format, entries); err != nil {
+				t.Fatalf("WriteEntries() errored: %v", err)
+			}
+			if diff := cmp.Diff(tt.expected, buf.String()); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func Test_SortEntriesByKey(t *testing.T) {
+	t.Parallel()
+	var entries []Entry
+	for _, key := range []string{"tenant-b", "10.0.0.10", "2001:db8::1", "10.0.0.9", "tenant-a", "9.0.0.1"} {
+		entries = append(entries, Entry{Key: key})
+	}
+	SortEntries(entries, "key")
+
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	expected := []string{"9.0.0.1", "10.0.0.9", "10.0.0.10", "2001:db8::1", "tenant-a", "tenant-b"}
+	if diff := cmp.Diff(expected, keys); diff != "" {
+		t.Errorf("SortEntries() mismatch (-want +got):\n%s", diff)
+	}
+}
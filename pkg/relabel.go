@@ -0,0 +1,164 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultMetricPrefix starts the names of the metrics when RelabelConfig.Prefix is empty
+const DefaultMetricPrefix = "haproxy_stick_table"
+
+// metricLabels are the labels of the metrics which can be renamed
+var metricLabels = []string{"client_ip", "name", "type", "data_type", "size", "period_ms"}
+
+// RelabelConfig customizes the names and the labels of the metrics, it applies to every backend
+// built on the Prometheus metrics.
+type RelabelConfig struct {
+	// Prefix replaces haproxy_stick_table at the start of the metric names, e.g. lb_stick_table
+	Prefix string
+	// LabelNames renames the labels of the metrics, e.g. client_ip=key or name=table
+	LabelNames map[string]string
+	// ExtraLabels are added to every metric, e.g. datacenter=par1 or cluster=edge
+	ExtraLabels map[string]string
+	// KeyRules rewrite the keys of the entries, the first matching rule applies and the keys
+	// without a matching rule are kept. The values of keys rewritten to the same value are summed.
+	KeyRules []KeyRule
+}
+
+// KeyRule rewrites the keys fully matched by Regex into Replacement, in which $1 or ${name}
+// refer to the groups of Regex
+type KeyRule struct {
+	Regex       *regexp.Regexp
+	Replacement string
+}
+
+// NewKeyRule returns a rule for a regex, which must match the whole key
+func NewKeyRule(regex string, replacement string) (KeyRule, error) {
+	re, err := regexp.Compile("^(?:" + regex + ")$")
+	if err != nil {
+		return KeyRule{}, fmt.Errorf("Invalid key regex '%s': %v", regex, err)
+	}
+	return KeyRule{Regex: re, Replacement: replacement}, nil
+}
+
+// ReadKeyRules parses the key rules in the file at path
+func ReadKeyRules(path string) ([]KeyRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseKeyRules(f)
+}
+
+// ParseKeyRules parses one rule per line, a regex and its replacement separated by spaces, e.g.
+// `10\.1\.\d+\.\d+ tenant-a`. Empty lines and lines starting with # are ignored.
+func ParseKeyRules(r io.Reader) ([]KeyRule, error) {
+	var rules []KeyRule
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Line %d: expected a regex and a replacement, got %d fields", lineNumber, len(fields))
+		}
+		rule, err := NewKeyRule(fields[0], fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", lineNumber, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Validate checks that the metric names and the labels are valid and that no label is used twice
+func (c RelabelConfig) Validate() error {
+	if c.Prefix != "" && !model.IsValidMetricName(model.LabelValue(c.Prefix)) {
+		return fmt.Errorf("Invalid metric prefix '%s'", c.Prefix)
+	}
+	known := make(map[string]bool, len(metricLabels))
+	for _, label := range metricLabels {
+		known[label] = true
+	}
+	for from, to := range c.LabelNames {
+		if !known[from] {
+			return fmt.Errorf("Unknown label '%s', expected one of %s", from, strings.Join(metricLabels, ", "))
+		}
+		if !model.LabelName(to).IsValid() || strings.HasPrefix(to, "__") {
+			return fmt.Errorf("Invalid label name '%s'", to)
+		}
+	}
+	used := make(map[string]string, len(metricLabels)+len(c.ExtraLabels))
+	for _, label := range metricLabels {
+		name := c.labelName(label)
+		if other, ok := used[name]; ok {
+			return fmt.Errorf("Labels %s and %s are both named %s", other, label, name)
+		}
+		used[name] = label
+	}
+	for name := range c.ExtraLabels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("Invalid label name '%s'", name)
+		}
+		if label, ok := used[name]; ok {
+			return fmt.Errorf("Extra label %s conflicts with label %s", name, label)
+		}
+	}
+
+	return nil
+}
+
+// metricName returns the name of a metric from the part following the prefix, the prefix
+// alone when suffix is empty
+func (c RelabelConfig) metricName(suffix string) string {
+	name := c.Prefix
+	if name == "" {
+		name = DefaultMetricPrefix
+	}
+	if suffix != "" {
+		name += "_" + suffix
+	}
+	return name
+}
+
+// labelName returns the name of a label after renaming
+func (c RelabelConfig) labelName(label string) string {
+	if name, ok := c.LabelNames[label]; ok {
+		return name
+	}
+	return label
+}
+
+// labelNames returns the names of labels after renaming
+func (c RelabelConfig) labelNames(labels ...string) []string {
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = c.labelName(label)
+	}
+	return names
+}
+
+// key returns a key rewritten by the first matching rule
+func (c RelabelConfig) key(key string) string {
+	for _, rule := range c.KeyRules {
+		if match := rule.Regex.FindStringSubmatchIndex(key); match != nil {
+			return string(rule.Regex.ExpandString(nil, rule.Replacement, key, match))
+		}
+	}
+	return key
+}
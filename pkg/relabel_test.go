@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_ParseKeyRules(t *testing.T) {
+	t.Parallel()
+	rules, err := ParseKeyRules(strings.NewReader(`
+# Tenants
+10\.1\.\d+\.\d+  tenant-a
+10\.(\d+)\..*    tenant-$1
+`))
+	if err != nil {
+		t.Fatalf("ParseKeyRules() errored: %v", err)
+	}
+	relabel := RelabelConfig{KeyRules: rules}
+	tests := map[string]string{
+		"10.1.2.3":    "tenant-a",
+		"10.2.0.1":    "tenant-2",
+		"110.1.2.3":   "110.1.2.3",
+		"2001:db8::1": "2001:db8::1",
+	}
+	for key, expected := range tests {
+		if got := relabel.key(key); got != expected {
+			t.Errorf("key(%s) = %s, want %s", key, got, expected)
+		}
+	}
+
+	for _, rules := range []string{"10.1.2.3", "10.1.2.3 tenant-a extra", "10.(1 tenant-a"} {
+		if _, err := ParseKeyRules(strings.NewReader(rules)); err == nil {
+			t.Errorf("ParseKeyRules(%s) didn't error", rules)
+		}
+	}
+}
+
+func Test_RelabelConfigValidate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		relabel RelabelConfig
+		wantErr bool
+	}{
+		{name: "empty"},
+		{
+			name: "valid",
+			relabel: RelabelConfig{
+				Prefix:      "lb_stick_table",
+				LabelNames:  map[string]string{"client_ip": "key", "name": "table"},
+				ExtraLabels: map[string]string{"datacenter": "par1", "name": "edge"},
+			},
+		},
+		{name: "invalid prefix", relabel: RelabelConfig{Prefix: "lb-stick-table"}, wantErr: true},
+		{name: "unknown label", relabel: RelabelConfig{LabelNames: map[string]string{"ip": "key"}}, wantErr: true},
+		{name: "invalid label name", relabel: RelabelConfig{LabelNames: map[string]string{"client_ip": "client-ip"}}, wantErr: true},
+		{name: "renamed to another label", relabel: RelabelConfig{LabelNames: map[string]string{"client_ip": "name"}}, wantErr: true},
+		{name: "extra label conflict", relabel: RelabelConfig{ExtraLabels: map[string]string{"client_ip": "x"}}, wantErr: true},
+		{name: "reserved extra label", relabel: RelabelConfig{ExtraLabels: map[string]string{"__name__": "x"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if err := tt.relabel.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_SetRelabeling(t *testing.T) {
+	t.Parallel()
+	rule, err := NewKeyRule(`10\.1\..*`, "tenant-a")
+	if err != nil {
+		t.Fatalf("NewKeyRule() errored: %v", err)
+	}
+	e := NewStickTableExporter(10, testLogger)
+	e.SetRelabeling(RelabelConfig{
+		Prefix:      "lb_stick_table",
+		LabelNames:  map[string]string{"client_ip": "key", "name": "table"},
+		ExtraLabels: map[string]string{"datacenter": "par1"},
+		KeyRules:    []KeyRule{rule},
+	})
+	e.SetThreshold("t1", 100)
+	e.UpdateData("t1", "http_req_rate", map[netip.Addr]int{
+		netip.MustParseAddr("10.1.0.1"): 150,
+		netip.MustParseAddr("10.1.0.2"): 120,
+		netip.MustParseAddr("10.2.0.1"): 20,
+	})
+
+	expected := `
+# HELP lb_stick_table Tracks the value of a data type, e.g. 'http_req_rate', per client IP address as observed by custom stick-table in HAProxy
+# TYPE lb_stick_table gauge
+lb_stick_table{data_type="http_req_rate",datacenter="par1",key="10.2.0.1",table="t1",type="ip"} 20
+lb_stick_table{data_type="http_req_rate",datacenter="par1",key="tenant-a",table="t1",type="ip"} 270
+# HELP lb_stick_table_entries_over_threshold Number of entries in a stick-table with a value above the configured threshold
+# TYPE lb_stick_table_entries_over_threshold gauge
+lb_stick_table_entries_over_threshold{datacenter="par1",table="t1"} 2
+# HELP lb_stick_table_over_threshold_key Value of the entries above the configured threshold, limited to the highest ones
+# TYPE lb_stick_table_over_threshold_key gauge
+lb_stick_table_over_threshold_key{datacenter="par1",key="tenant-a",table="t1"} 150
+`
+	if err := testutil.GatherAndCompare(e.Gatherer(), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
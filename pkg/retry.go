@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries the commands failing on a transient error, e.g. while HAProxy
+// reloads and replaces its socket, waiting longer after every attempt.
+type RetryPolicy struct {
+	// Retries is the number of attempts after the first one, zero to never retry
+	Retries int
+	// Backoff is the delay before the first retry, doubled after every retry
+	Backoff time.Duration
+	// MaxBackoff bounds the delay between two attempts, zero for no bound
+	MaxBackoff time.Duration
+}
+
+// delay returns the time to wait before the retry following the attempt, with a random
+// jitter so clients started together don't retry at the same time.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff == 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + rand.N(d/2+1)
+}
+
+// isRetryable reports whether a command failed on an error which may not happen on a new attempt:
+// the socket is missing or refuses connections during a reload, or a stage of the command timed out.
+func isRetryable(err error) bool {
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var timeoutErr *TimeoutError
+	// There is no time left for another attempt after the overall timeout
+	return errors.As(err, &timeoutErr) && timeoutErr.Stage != StageOverall
+}
+
+// attemptsKey is the key of the counter of the attempts in a context
+type attemptsKey struct{}
+
+// withAttemptCounter returns a context counting the attempts of the commands sent with it in
+// counter, retries included. Unlike Client.Attempts, it isn't shared by the concurrent calls.
+func withAttemptCounter(ctx context.Context, counter *atomic.Int64) context.Context {
+	return context.WithValue(ctx, attemptsKey{}, counter)
+}
+
+// retry calls exec until it succeeds, fails on an error which isn't retryable or runs out of attempts
+func (c *Client) retry(ctx context.Context, cmd string, exec func() (string, error)) (string, error) {
+	for attempt := 1; ; attempt++ {
+		c.attempts.Add(1)
+		if counter, ok := ctx.Value(attemptsKey{}).(*atomic.Int64); ok {
+			counter.Add(1)
+		}
+		response, err := exec()
+		if err == nil || attempt > c.Retry.Retries || !isRetryable(err) {
+			return response, err
+		}
+
+		delay := c.Retry.delay(attempt)
+		c.logger().Warn("Retrying command", "command", cmd, "attempt", attempt, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", err
+		case <-timer.C:
+		}
+	}
+}
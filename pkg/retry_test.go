@@ -0,0 +1,92 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_isRetryable(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "missing socket", err: fmt.Errorf("Failed to connect: %w", &os.SyscallError{Syscall: "connect", Err: syscall.ENOENT}), expected: true},
+		{name: "connection refused", err: fmt.Errorf("Failed to connect: %w", syscall.ECONNREFUSED), expected: true},
+		{name: "read timeout", err: fmt.Errorf("Error reading from socket: %w", &TimeoutError{Stage: StageRead}), expected: true},
+		{name: "overall timeout", err: &TimeoutError{Stage: StageOverall}, expected: false},
+		{name: "canceled", err: context.Canceled, expected: false},
+		{name: "other error", err: errors.New("table argument cannot be empty"), expected: false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.expected {
+			t.Errorf("isRetryable(%s) = %v, want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func Test_RetryPolicyDelay(t *testing.T) {
+	t.Parallel()
+	p := RetryPolicy{Retries: 10, Backoff: 100 * time.Millisecond, MaxBackoff: 1 * time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: 100 * time.Millisecond},
+		{attempt: 2, max: 200 * time.Millisecond},
+		{attempt: 3, max: 400 * time.Millisecond},
+		{attempt: 10, max: 1 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if d := p.delay(tt.attempt); d < tt.max/2 || d > tt.max {
+				t.Fatalf("delay(%d) = %s, want between %s and %s", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func Test_ClientRetry(t *testing.T) {
+	t.Parallel()
+	socket := startTestServer(t, testResponses)
+	// HAProxy removes its socket for a short time when it reloads
+	if err := os.Rename(socket, socket+".reload"); err != nil {
+		t.Fatalf("Failed to move socket: %v", err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { os.Rename(socket+".reload", socket) })
+
+	client := NewClient(socket, Timeouts{Overall: 5 * time.Second}, testLogger)
+	if _, err := client.Exec(context.Background(), "show info"); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("Exec() without retries = %v, want ENOENT", err)
+	}
+	if client.Attempts() != 1 {
+		t.Errorf("Attempts() = %d without retries, want 1", client.Attempts())
+	}
+
+	client.Retry = RetryPolicy{Retries: 10, Backoff: 20 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	var attempts atomic.Int64
+	if _, err := client.Exec(withAttemptCounter(context.Background(), &attempts), "show info"); err != nil {
+		t.Fatalf("Exec() errored: %v", err)
+	}
+	if client.Attempts() < 3 {
+		t.Errorf("Attempts() = %d, expected retries while the socket was missing", client.Attempts())
+	}
+	// The counter of a call doesn't count the attempts of the other calls
+	if attempts.Load() != client.Attempts()-1 {
+		t.Errorf("attempts of the call = %d, want %d", attempts.Load(), client.Attempts()-1)
+	}
+	var other atomic.Int64
+	if _, err := client.Exec(withAttemptCounter(context.Background(), &other), "show info"); err != nil {
+		t.Fatalf("Exec() errored: %v", err)
+	}
+	if other.Load() != 1 {
+		t.Errorf("attempts of the other call = %d, want 1", other.Load())
+	}
+}
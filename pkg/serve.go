@@ -0,0 +1,83 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+// MetricsHandler queries the stick-tables at every scrape and serves their metrics, in
+// OpenMetrics when the scraper accepts it or in Prometheus text format otherwise.
+func MetricsHandler(cfg Config) http.Handler {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricsExporter, err := Collect(r.Context(), cfg)
+		if err != nil {
+			logger.Error("Failed to query stick-tables", "error", err, "error_class", ErrorClass(err))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		// promhttp doesn't write the units, the OpenMetrics encoding is done here instead
+		if expfmt.NegotiateIncludingOpenMetrics(r.Header).FormatType() == expfmt.TypeOpenMetrics {
+			w.Header().Set("Content-Type", string(expfmt.NewFormat(expfmt.TypeOpenMetrics)))
+			if err := encodeOpenMetrics(w, metricsExporter.Gatherer()); err != nil {
+				logger.Error("Failed to encode metrics", "error", err)
+			}
+			return
+		}
+		promhttp.HandlerFor(metricsExporter.Gatherer(), promhttp.HandlerOpts{
+			ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		}).ServeHTTP(w, r)
+	})
+}
+
+// Serve serves the metrics on /metrics at the address until ctx is canceled. The stick-tables
+// are queried at every scrape over a session kept open between scrapes.
+func Serve(ctx context.Context, cfg Config, address string) error {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if cfg.Counters == nil {
+		cfg.Counters = NewCounterTracker(DefaultCounterRetention)
+	}
+	if cfg.Client == nil {
+		cfg.Client = NewClient(cfg.Socket, cfg.Timeouts, logger.With("socket", cfg.Socket))
+		cfg.Client.Retry = cfg.Retry
+		cfg.Client.Persistent(1)
+		defer cfg.Client.Close()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler(cfg))
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	logger.Info("Serving metrics", "address", address)
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("Failed to serve metrics: %v", err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_MetricsHandler(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name               string
+		file               string
+		accept             string
+		timestamps         bool
+		expectedStatus     int
+		expectedType       string
+		expected           []string
+		expectedTimestamps bool
+	}{
+		{
+			name:           "text format",
+			file:           "table_requests_limiter_src_ip.dump",
+			expectedStatus: http.StatusOK,
+			expectedType:   "text/plain",
+			expected: []string{
+				`haproxy_stick_table{client_ip="1.32.20.122",data_type="http_req_rate",name="table_requests_limiter_src_ip",type="ip"} 1`,
+			},
+		},
+		{
+			name:           "openmetrics format",
+			file:           "table_requests_limiter_src_ip.dump",
+			accept:         "application/openmetrics-text; version=1.0.0",
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/openmetrics-text",
+			expected: []string{
+				`haproxy_stick_table{client_ip="1.32.20.122",data_type="http_req_rate",name="table_requests_limiter_src_ip",type="ip"} 1.0`,
+				"# UNIT haproxy_stick_table_query_duration_seconds seconds",
+				"# EOF",
+			},
+		},
+		{
+			name:               "openmetrics format with timestamps",
+			file:               "table_requests_limiter_src_ip.dump",
+			accept:             "application/openmetrics-text; version=1.0.0",
+			timestamps:         true,
+			expectedStatus:     http.StatusOK,
+			expectedType:       "application/openmetrics-text",
+			expectedTimestamps: true,
+		},
+		{
+			name:           "query failure",
+			file:           "missing.dump",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+	timestamp := regexp.MustCompile(`(?m)^haproxy_stick_table\{.*\} \S+ \S+$`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(MetricsHandler(Config{
+				Tables:     []Table{{Name: "table_requests_limiter_src_ip", DataType: "http_req_rate"}},
+				FromFile:   filepath.Join("testdata", tt.file),
+				Timestamps: tt.timestamps,
+				Logger:     testLogger,
+			}))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to scrape metrics: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read metrics: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, tt.expectedType) {
+				t.Errorf("Content-Type = %s, want %s", contentType, tt.expectedType)
+			}
+			for _, line := range tt.expected {
+				if !strings.Contains(string(body), line+"\n") {
+					t.Errorf("metrics don't contain --%s--\n%s", line, body)
+				}
+			}
+			if got := timestamp.Match(body); got != tt.expectedTimestamps {
+				t.Errorf("timestamps = %v, want %v\n%s", got, tt.expectedTimestamps, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BackendStatsD sends the metrics to a DogStatsD server, e.g. the Datadog agent
+const BackendStatsD = "statsd"
+
+// Tags identifying the entries in the StatsD metrics
+const (
+	// StatsDKeyTagKey tags every entry with its client IP address
+	StatsDKeyTagKey = "key"
+	// StatsDKeyTagPrefix sums the entries per network prefix and tags them with it, to bound the
+	// number of tag values: a /24 for IPv4 and a /64 for IPv6
+	StatsDKeyTagPrefix = "prefix"
+)
+
+// Defaults of StatsDConfig
+const (
+	DefaultStatsDPrefix = "haproxy.stick_table"
+	// DefaultStatsDMTU keeps the packets under the MTU of ethernet minus the IP and UDP headers
+	DefaultStatsDMTU = 1432
+)
+
+// StatsDConfig sends the metrics as DogStatsD gauges over UDP. The entries are sent as
+// <prefix>.<data type> tagged with table, type and key or prefix; the table stats, such as
+// the number of entries and the query duration, as <prefix>.<stat> tagged with table. The keys
+// are relabeled and anonymized as in the Prometheus metrics, whose extra labels tag every metric.
+type StatsDConfig struct {
+	// Address is the host:port of the DogStatsD server
+	Address string
+	// Prefix is prepended to the metric names, DefaultStatsDPrefix when empty
+	Prefix string
+	// Tags are added to every metric, e.g. env:prod
+	Tags []string
+	// KeyTag is StatsDKeyTagKey or StatsDKeyTagPrefix, key when empty
+	KeyTag string
+	// MTU bounds the size of a packet, the metrics are batched into as few packets as possible.
+	// DefaultStatsDMTU when zero.
+	MTU int
+}
+
+// Write sends the metrics of the tables to the DogStatsD server
+func (c StatsDConfig) Write(ctx context.Context, e *StickTableExporter) error {
+	lines, err := c.lines(e)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", c.Address)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to StatsD server %s: %v", c.Address, err)
+	}
+	defer conn.Close()
+
+	packets := batchLines(lines, c.mtu())
+	for _, packet := range packets {
+		if _, err := conn.Write(packet); err != nil {
+			return fmt.Errorf("Failed to send metrics to StatsD server %s: %v", c.Address, err)
+		}
+	}
+	e.logger.Debug("Sent metrics to StatsD", "address", c.Address, "metrics", len(lines), "packets", len(packets))
+
+	return nil
+}
+
+func (c StatsDConfig) mtu() int {
+	if c.MTU <= 0 {
+		return DefaultStatsDMTU
+	}
+	return c.MTU
+}
+
+func (c StatsDConfig) prefix() string {
+	if c.Prefix == "" {
+		return DefaultStatsDPrefix
+	}
+	return c.Prefix
+}
+
+// gauge returns a DogStatsD gauge, the tags of the config are appended to the tags
+func (c StatsDConfig) gauge(name string, value float64, tags ...string) string {
+	tags = append(tags, c.Tags...)
+	for i, tag := range tags {
+		tags[i] = statsDSanitizer.Replace(tag)
+	}
+	line := statsDSanitizer.Replace(c.prefix()+"."+name) + ":" + strconv.FormatFloat(value, 'g', -1, 64) + "|g"
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// statsDSanitizer replaces the characters delimiting the fields of a DogStatsD datagram
+var statsDSanitizer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_", "@", "_")
+
+// lines returns the gauges of the entries and the stats of every table
+func (c StatsDConfig) lines(e *StickTableExporter) ([]string, error) {
+	var lines []string
+	// The extra labels are already on the table stats taken from the Prometheus metrics
+	extraNames := make([]string, 0, len(e.relabel.ExtraLabels))
+	for name := range e.relabel.ExtraLabels {
+		extraNames = append(extraNames, name)
+	}
+	sort.Strings(extraNames)
+	extraTags := make([]string, 0, len(extraNames))
+	for _, name := range extraNames {
+		extraTags = append(extraTags, name+":"+e.relabel.ExtraLabels[name])
+	}
+	names := make([]string, 0, len(e.tables))
+	for name := range e.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := e.tables[name]
+		tableTag := "table:" + name
+		var keyTag func(ip netip.Addr) string
+		switch c.KeyTag {
+		case StatsDKeyTagKey, "":
+			keyTag = func(ip netip.Addr) string { return "key:" + e.key(ip) }
+		case StatsDKeyTagPrefix:
+			keyTag = func(ip netip.Addr) string { return "prefix:" + e.relabel.key(e.anonymizer.Prefix(keyPrefix(ip))) }
+		default:
+			return nil, fmt.Errorf("Unsupported StatsD key tag '%s'", c.KeyTag)
+		}
+
+		ips := make([]netip.Addr, 0, len(t.stickData))
+		for ip := range t.stickData {
+			ips = append(ips, ip)
+		}
+		sort.Slice(ips, func(i, j int) bool { return ips[i].Less(ips[j]) })
+		// The values of the addresses with the same tag, in the same network or anonymized to
+		// the same key, are summed
+		var tags []string
+		sums := make(map[string]int)
+		for _, ip := range ips {
+			tag := keyTag(ip)
+			if _, ok := sums[tag]; !ok {
+				tags = append(tags, tag)
+			}
+			sums[tag] += t.stickData[ip]
+		}
+		for _, tag := range tags {
+			lines = append(lines, c.gauge(t.dataType, float64(sums[tag]), append([]string{tableTag, "type:ip", tag}, extraTags...)...))
+		}
+		lines = append(lines, c.gauge("entries", float64(len(t.stickData)), append([]string{tableTag}, extraTags...)...))
+	}
+
+	// The table stats are taken from the Prometheus metrics, with the name label as table tag
+	families, err := e.Gatherer().Gather()
+	if err != nil {
+		return nil, err
+	}
+	prefix := e.relabel.metricName("")
+	tableLabel := e.relabel.labelName("name")
+	for _, mf := range families {
+		if mf.GetName() == prefix {
+			continue
+		}
+		stat := strings.TrimPrefix(mf.GetName(), prefix+"_")
+		switch stat {
+		case "info", "over_threshold_key", "counter_total", "counter_delta":
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			var tags []string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == tableLabel {
+					tags = append(tags, "table:"+l.GetValue())
+				} else {
+					tags = append(tags, l.GetName()+":"+l.GetValue())
+				}
+			}
+			lines = append(lines, c.gauge(stat, metricValue(m), tags...))
+		}
+	}
+
+	return lines, nil
+}
+
+// keyPrefix returns the network of an address used as StatsD tag: a /24 for IPv4 and a /64 for IPv6
+func keyPrefix(ip netip.Addr) netip.Prefix {
+	bits := 64
+	if ip.Is4() {
+		bits = 24
+	}
+	p, _ := ip.Prefix(bits)
+	return p
+}
+
+// batchLines joins the lines with newlines into packets of up to mtu bytes, a line longer
+// than mtu is sent in a packet of its own.
+func batchLines(lines []string, mtu int) [][]byte {
+	var packets [][]byte
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > mtu {
+			packets = append(packets, packet)
+			packet = nil
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		packets = append(packets, packet)
+	}
+	return packets
+}
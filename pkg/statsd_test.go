@@ -0,0 +1,128 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_StatsDConfigWrite(t *testing.T) {
+	t.Parallel()
+	tenantRule, err := NewKeyRule(`10\.0\.0\..*`, "tenant-a")
+	if err != nil {
+		t.Fatalf("NewKeyRule() errored: %v", err)
+	}
+	tests := []struct {
+		name     string
+		keyTag   string
+		relabel  RelabelConfig
+		expected []string
+	}{
+		{
+			name:   "key",
+			keyTag: StatsDKeyTagKey,
+			expected: []string{
+				"haproxy.stick_table.http_req_rate:5|g|#table:t1,type:ip,key:10.0.0.1,env:test",
+				"haproxy.stick_table.http_req_rate:7|g|#table:t1,type:ip,key:10.0.0.2,env:test",
+				"haproxy.stick_table.http_req_rate:3|g|#table:t1,type:ip,key:2001:db8::1,env:test",
+				"haproxy.stick_table.entries:3|g|#table:t1,env:test",
+				"haproxy.stick_table.malformed_lines:2|g|#table:t1,env:test",
+			},
+		},
+		{
+			name:   "prefix",
+			keyTag: StatsDKeyTagPrefix,
+			expected: []string{
+				"haproxy.stick_table.http_req_rate:12|g|#table:t1,type:ip,prefix:10.0.0.0/24,env:test",
+				"haproxy.stick_table.http_req_rate:3|g|#table:t1,type:ip,prefix:2001:db8::/64,env:test",
+				"haproxy.stick_table.entries:3|g|#table:t1,env:test",
+				"haproxy.stick_table.malformed_lines:2|g|#table:t1,env:test",
+			},
+		},
+		{
+			name:    "relabeled",
+			keyTag:  StatsDKeyTagKey,
+			relabel: RelabelConfig{ExtraLabels: map[string]string{"datacenter": "par1"}, KeyRules: []KeyRule{tenantRule}},
+			expected: []string{
+				"haproxy.stick_table.http_req_rate:12|g|#table:t1,type:ip,key:tenant-a,datacenter:par1,env:test",
+				"haproxy.stick_table.http_req_rate:3|g|#table:t1,type:ip,key:2001:db8::1,datacenter:par1,env:test",
+				"haproxy.stick_table.entries:3|g|#table:t1,datacenter:par1,env:test",
+				"haproxy.stick_table.malformed_lines:2|g|#datacenter:par1,table:t1,env:test",
+			},
+		},
+		{
+			name:    "relabeled prefix",
+			keyTag:  StatsDKeyTagPrefix,
+			relabel: RelabelConfig{ExtraLabels: map[string]string{"datacenter": "par1"}, KeyRules: []KeyRule{tenantRule}},
+			expected: []string{
+				"haproxy.stick_table.http_req_rate:12|g|#table:t1,type:ip,prefix:tenant-a,datacenter:par1,env:test",
+				"haproxy.stick_table.http_req_rate:3|g|#table:t1,type:ip,prefix:2001:db8::/64,datacenter:par1,env:test",
+				"haproxy.stick_table.entries:3|g|#table:t1,datacenter:par1,env:test",
+				"haproxy.stick_table.malformed_lines:2|g|#datacenter:par1,table:t1,env:test",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %v", err)
+			}
+			defer listener.Close()
+
+			e := NewStickTableExporter(10, testLogger)
+			e.SetRelabeling(tt.relabel)
+			e.SetMalformedLines("t1", 2)
+			e.UpdateData("t1", "http_req_rate", map[netip.Addr]int{
+				netip.MustParseAddr("10.0.0.1"):    5,
+				netip.MustParseAddr("10.0.0.2"):    7,
+				netip.MustParseAddr("2001:db8::1"): 3,
+			})
+			cfg := StatsDConfig{Address: listener.LocalAddr().String(), Tags: []string{"env:test"}, KeyTag: tt.keyTag}
+			if err := cfg.Write(context.Background(), e); err != nil {
+				t.Fatalf("Write() errored: %v", err)
+			}
+
+			listener.SetReadDeadline(time.Now().Add(time.Second))
+			buf := make([]byte, 65536)
+			n, _, err := listener.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("Failed to read packet: %v", err)
+			}
+			if diff := cmp.Diff(tt.expected, strings.Split(string(buf[:n]), "\n")); diff != "" {
+				t.Errorf("packet mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_batchLines(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		lines    []string
+		mtu      int
+		expected []string
+	}{
+		{name: "single packet", lines: []string{"a:1|g", "b:2|g"}, mtu: 100, expected: []string{"a:1|g\nb:2|g"}},
+		{name: "exact fit", lines: []string{"a:1|g", "b:2|g"}, mtu: 11, expected: []string{"a:1|g\nb:2|g"}},
+		{name: "split", lines: []string{"a:1|g", "b:2|g", "c:3|g"}, mtu: 10, expected: []string{"a:1|g", "b:2|g", "c:3|g"}},
+		{name: "line over mtu", lines: []string{"long:12345|g", "b:2|g"}, mtu: 8, expected: []string{"long:12345|g", "b:2|g"}},
+		{name: "no lines", lines: nil, mtu: 10, expected: nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, packet := range batchLines(tt.lines, tt.mtu) {
+			got = append(got, string(packet))
+		}
+		if diff := cmp.Diff(tt.expected, got); diff != "" {
+			t.Errorf("batchLines(%s) mismatch (-want +got):\n%s", tt.name, diff)
+		}
+	}
+}
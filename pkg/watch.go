@@ -0,0 +1,142 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ANSI escape sequences used to render the watch view
+const (
+	ansiClear = "\033[H\033[2J"
+	ansiGreen = "\033[32m"
+	ansiRed   = "\033[31m"
+	ansiReset = "\033[0m"
+)
+
+// TopTalkerRow is a key of the watch view
+type TopTalkerRow struct {
+	Key   string
+	Value int
+	// Delta is the change of the value since the previous refresh
+	Delta int
+	// New is true when the key wasn't in the table at the previous refresh
+	New bool
+	// Gone is true when the key was shown at the previous refresh but isn't in the table anymore
+	Gone bool
+}
+
+// TopTalkers tracks the value of a data type per key between refreshes of a stick-table
+type TopTalkers struct {
+	dataType string
+	limit    int
+	// previous holds the value of every key at the previous refresh, nil before the first one
+	previous map[string]int
+	// shown holds the keys of the previous view
+	shown map[string]bool
+}
+
+// NewTopTalkers returns a tracker showing the limit keys with the highest value of dataType
+func NewTopTalkers(dataType string, limit int) *TopTalkers {
+	return &TopTalkers{dataType: dataType, limit: limit}
+}
+
+// Update returns the rows of the view for the current entries, the top keys by value
+// followed by the keys of the previous view which disappeared from the table.
+func (t *TopTalkers) Update(entries []Entry) []TopTalkerRow {
+	current := make(map[string]int, len(entries))
+	for _, e := range entries {
+		if v, ok := e.Value(t.dataType); ok {
+			current[e.Key] = v
+		}
+	}
+
+	rows := make([]TopTalkerRow, 0, len(current))
+	for key, value := range current {
+		row := TopTalkerRow{Key: key, Value: value}
+		if prev, ok := t.previous[key]; ok {
+			row.Delta = value - prev
+		} else if t.previous != nil {
+			row.New = true
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Value != rows[j].Value {
+			return rows[i].Value > rows[j].Value
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	if t.limit > 0 && len(rows) > t.limit {
+		rows = rows[:t.limit]
+	}
+
+	var gone []TopTalkerRow
+	for key := range t.shown {
+		if _, ok := current[key]; !ok {
+			prev := t.previous[key]
+			gone = append(gone, TopTalkerRow{Key: key, Value: prev, Delta: -prev, Gone: true})
+		}
+	}
+	sort.Slice(gone, func(i, j int) bool { return gone[i].Key < gone[j].Key })
+
+	t.previous = current
+	t.shown = make(map[string]bool, len(rows))
+	for _, row := range rows {
+		t.shown[row.Key] = true
+	}
+
+	return append(rows, gone...)
+}
+
+// RenderTopTalkers clears the terminal and draws the header stats of the table followed by the rows.
+// New keys are drawn in green and gone keys in red when color is true.
+func RenderTopTalkers(w io.Writer, header TableHeader, dataType string, rows []TopTalkerRow, color bool, now time.Time) error {
+	fmt.Fprint(w, ansiClear)
+	fmt.Fprintf(w, "%s  table: %s  type: %s  used: %d/%d\n\n",
+		now.Format(time.TimeOnly), header.Name, header.Type, header.Used, header.Size)
+
+	// Rows are aligned before being colored, escape sequences would break the alignment
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "KEY\t%s\tDELTA\t\n", strings.ToUpper(dataType))
+	for _, row := range rows {
+		delta := strconv.Itoa(row.Delta)
+		if row.Delta > 0 {
+			delta = "+" + delta
+		}
+		status := ""
+		switch {
+		case row.New:
+			status = "new"
+		case row.Gone:
+			status = "gone"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", row.Key, row.Value, delta, status)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	lines := strings.SplitAfter(buf.String(), "\n")
+	fmt.Fprint(w, lines[0])
+	for i, row := range rows {
+		line := lines[i+1]
+		switch {
+		case color && row.New:
+			line = ansiGreen + strings.TrimSuffix(line, "\n") + ansiReset + "\n"
+		case color && row.Gone:
+			line = ansiRed + strings.TrimSuffix(line, "\n") + ansiReset + "\n"
+		}
+		if _, err := fmt.Fprint(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
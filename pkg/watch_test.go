@@ -0,0 +1,35 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_TopTalkers(t *testing.T) {
+	t.Parallel()
+	entry := func(key string, value int) Entry {
+		return Entry{Key: key, Data: []DataValue{{Name: "http_req_rate", Period: 60000, Value: value}}}
+	}
+	topTalkers := NewTopTalkers("http_req_rate", 2)
+
+	// Nothing is new at the first refresh
+	rows := topTalkers.Update([]Entry{entry("1.1.1.1", 10), entry("2.2.2.2", 20), entry("3.3.3.3", 5)})
+	expected := []TopTalkerRow{
+		{Key: "2.2.2.2", Value: 20},
+		{Key: "1.1.1.1", Value: 10},
+	}
+	if diff := cmp.Diff(expected, rows); diff != "" {
+		t.Error(diff)
+	}
+
+	rows = topTalkers.Update([]Entry{entry("1.1.1.1", 15), entry("3.3.3.3", 5), entry("4.4.4.4", 30)})
+	expected = []TopTalkerRow{
+		{Key: "4.4.4.4", Value: 30, New: true},
+		{Key: "1.1.1.1", Value: 15, Delta: 5},
+		{Key: "2.2.2.2", Value: 20, Delta: -20, Gone: true},
+	}
+	if diff := cmp.Diff(expected, rows); diff != "" {
+		t.Error(diff)
+	}
+}